@@ -0,0 +1,265 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrRolledBack         = errors.New("rename failed and every completed rename was rolled back")
+	ErrParentNotDirectory = errors.New("a parent of the new path is a file")
+)
+
+// pathMapper tracks directories that were renamed during a run so paths
+// planned against the original tree can be translated to where they are now.
+type pathMapper struct {
+	renames []Operation
+}
+
+// A nil mapper leaves every path as it is.
+func (m *pathMapper) add(source, target string) {
+	if m == nil {
+		return
+	}
+	m.renames = append(m.renames, Operation{Source: source, Target: target})
+}
+
+func (m *pathMapper) forward(path string) string {
+	if m == nil {
+		return path
+	}
+	for _, r := range m.renames {
+		if path == r.Source {
+			path = r.Target
+		} else if strings.HasPrefix(path, r.Source+string(filepath.Separator)) {
+			path = r.Target + path[len(r.Source):]
+		}
+	}
+	return path
+}
+
+func depthOf(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// executionOrder returns the indexes of the operations that rename something,
+// deepest first. Every entry is renamed before its parent directory, so the
+// source of an operation is still at its planned path when it runs.
+func (p Plan) executionOrder() []int {
+	var order []int
+	for i, op := range p.Operations {
+		if op.Err == nil && !op.Unchanged() {
+			order = append(order, i)
+		}
+	}
+	if p.Sequential {
+		return order
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return depthOf(p.Operations[order[a]].Source) > depthOf(p.Operations[order[b]].Source)
+	})
+	return order
+}
+
+// finalTargets works out where every entry ends up once all of its parent
+// directories have been renamed as well.
+func (p Plan) finalTargets() []string {
+	targets := make([]string, len(p.Operations))
+	mapper := &pathMapper{}
+	if p.Sequential {
+		for i, op := range p.Operations {
+			targets[i] = op.Target
+		}
+		return targets
+	}
+	for _, i := range p.executionOrder() {
+		op := p.Operations[i]
+		if op.IsDir {
+			mapper.add(op.Source, mapper.forward(op.Target))
+		}
+	}
+	for i, op := range p.Operations {
+		targets[i] = mapper.forward(op.Target)
+	}
+	return targets
+}
+
+// apply renames every entry deepest first and never overwrites an existing
+// file. When a target is still held by an entry that is renamed later, for
+// example when two files swap names, the entry is moved to a temporary name
+// first and put in place once everything else is done. If any rename fails
+// the ones already done are rolled back, so the tree is either fully renamed
+// or left as it was. The renames that were done, including moves to and from
+// temporary names, are returned in the order they happened, together with the
+// directories that were created for targets in directories that didn't exist.
+func (p Plan) apply(onDone func()) ([]Operation, []string, error) {
+	order := p.executionOrder()
+	pending := map[string]bool{}
+	for _, i := range order {
+		pending[p.Operations[i].Source] = true
+	}
+	for i, op := range p.Operations {
+		if op.Err == nil && op.Unchanged() {
+			onDone()
+		} else if op.Err != nil {
+			return nil, nil, fmt.Errorf("renaming %s: %w", p.Operations[i].Source, op.Err)
+		}
+	}
+
+	type staged struct {
+		temp string
+		op   Operation
+	}
+	var deferred []staged
+	var moves []Operation
+	var created []string
+	mapper := &pathMapper{}
+	if p.Sequential {
+		mapper = nil
+	}
+	move := func(source, target string, isDir bool) error {
+		dirs, errParent := createParents(filepath.Dir(target))
+		created = append(created, dirs...)
+		if errParent != nil {
+			return fmt.Errorf("renaming %s: %w", source, errParent)
+		}
+		errRename := p.git.rename(source, target)
+		if errRename != nil {
+			return fmt.Errorf("renaming %s: %w", source, errRename)
+		}
+		moves = append(moves, Operation{Source: source, Target: target, IsDir: isDir})
+		return nil
+	}
+
+	for _, i := range order {
+		op := p.Operations[i]
+		op.Target = mapper.forward(op.Target)
+		delete(pending, op.Source)
+		holder, errHolder := occupant(op.Source, op.Target)
+		if errHolder != nil {
+			return p.rollback(moves, created, errHolder)
+		}
+		switch {
+		case holder == "":
+			if errMove := move(op.Source, op.Target, op.IsDir); errMove != nil {
+				return p.rollback(moves, created, errMove)
+			}
+			if op.IsDir {
+				mapper.add(op.Source, op.Target)
+			}
+		case pending[holder]:
+			temp := filepath.Join(filepath.Dir(op.Source), fmt.Sprintf(".%s.renaming-%d", filepath.Base(op.Source), i))
+			if errMove := move(op.Source, temp, op.IsDir); errMove != nil {
+				return p.rollback(moves, created, errMove)
+			}
+			if op.IsDir {
+				mapper.add(op.Source, temp)
+			}
+			deferred = append(deferred, staged{temp: temp, op: op})
+		default:
+			return p.rollback(moves, created, fmt.Errorf("renaming %s: %w: %s", op.Source, ErrTargetExists, holder))
+		}
+		onDone()
+	}
+	for _, s := range deferred {
+		target := mapper.forward(s.op.Target)
+		if _, errStat := os.Lstat(target); errStat == nil {
+			return p.rollback(moves, created, fmt.Errorf("renaming %s: %w: %s", s.temp, ErrTargetExists, target))
+		}
+		if errMove := move(mapper.forward(s.temp), target, s.op.IsDir); errMove != nil {
+			return p.rollback(moves, created, errMove)
+		}
+		if s.op.IsDir {
+			mapper.add(mapper.forward(s.temp), target)
+		}
+	}
+	return moves, created, nil
+}
+
+// rollback reverses moves newest first and removes the directories created
+// for them. The moves that couldn't be reversed are returned so they can
+// still be recorded in a journal.
+func (p Plan) rollback(moves []Operation, created []string, cause error) ([]Operation, []string, error) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		errRename := p.git.rename(moves[i].Target, moves[i].Source)
+		if errRename != nil {
+			return moves[:i+1], created, fmt.Errorf("%w, rolling back %s also failed: %v", cause, moves[i].Target, errRename)
+		}
+	}
+	removeDirectories(created)
+	return nil, nil, fmt.Errorf("%w: %w", ErrRolledBack, cause)
+}
+
+// createParents creates dir and any missing directories above it and returns
+// the ones it created, outermost first.
+func createParents(dir string) ([]string, error) {
+	var missing []string
+	for path := dir; ; path = filepath.Dir(path) {
+		info, errStat := os.Stat(path)
+		if errStat == nil {
+			if !info.IsDir() {
+				return nil, fmt.Errorf("%w: %s", ErrParentNotDirectory, path)
+			}
+			break
+		}
+		if !errors.Is(errStat, fs.ErrNotExist) || filepath.Dir(path) == path {
+			return nil, errStat
+		}
+		missing = append(missing, path)
+	}
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		if errMkdir := os.Mkdir(missing[i], 0o755); errMkdir != nil {
+			return created, errMkdir
+		}
+		created = append(created, missing[i])
+	}
+	return created, nil
+}
+
+// removeDirectories removes the directories innermost first. Directories that
+// aren't empty anymore are kept.
+func removeDirectories(dirs []string) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
+
+// occupant returns the path currently holding target, or an empty string if
+// the target is free or only held by source itself, which happens with case
+// only renames on case-insensitive volumes.
+func occupant(source, target string) (string, error) {
+	targetInfo, errStat := os.Lstat(target)
+	if errors.Is(errStat, fs.ErrNotExist) {
+		return "", nil
+	}
+	if errStat != nil {
+		return "", errStat
+	}
+	sourceInfo, errStat := os.Lstat(source)
+	if errStat != nil {
+		return "", errStat
+	}
+	if os.SameFile(sourceInfo, targetInfo) {
+		return "", nil
+	}
+	entries, errRead := os.ReadDir(filepath.Dir(target))
+	if errRead != nil {
+		return "", errRead
+	}
+	holder := target
+	for _, entry := range entries {
+		if entry.Name() == filepath.Base(target) {
+			return target, nil
+		}
+		if strings.EqualFold(entry.Name(), filepath.Base(target)) {
+			holder = filepath.Join(filepath.Dir(target), entry.Name())
+		}
+	}
+	return holder, nil
+}
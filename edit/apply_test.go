@@ -0,0 +1,150 @@
+package edit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTree creates the entries below root. Paths ending in a slash are
+// directories, everything else is a file with its path as content.
+func writeTree(t *testing.T, root string, entries []string) {
+	t.Helper()
+	for _, entry := range entries {
+		path := filepath.Join(root, filepath.FromSlash(entry))
+		if entry[len(entry)-1] == '/' {
+			if errMkdir := os.MkdirAll(path, 0o755); errMkdir != nil {
+				t.Fatal(errMkdir)
+			}
+			continue
+		}
+		if errMkdir := os.MkdirAll(filepath.Dir(path), 0o755); errMkdir != nil {
+			t.Fatal(errMkdir)
+		}
+		if errWrite := os.WriteFile(path, []byte(entry), 0o644); errWrite != nil {
+			t.Fatal(errWrite)
+		}
+	}
+}
+
+// readTree returns every entry below root like writeTree takes them, mapped
+// to the content of files.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	errWalk := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			tree[rel+"/"] = ""
+			return nil
+		}
+		content, errRead := os.ReadFile(path)
+		tree[rel] = string(content)
+		return errRead
+	})
+	if errWalk != nil {
+		t.Fatal(errWalk)
+	}
+	return tree
+}
+
+func TestPlanApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		tree    []string
+		renames [][2]string
+		want    map[string]string
+		wantErr error
+	}{
+		{
+			name:    "swap",
+			tree:    []string{"a", "b"},
+			renames: [][2]string{{"a", "b"}, {"b", "a"}},
+			want:    map[string]string{"a": "b", "b": "a"},
+		},
+		{
+			name:    "nested directory and file",
+			tree:    []string{"Dir/Sub Dir/File.txt", "Dir/Other.txt"},
+			renames: [][2]string{{"Dir", "dir"}, {"Dir/Sub Dir", "Dir/sub-dir"}, {"Dir/Sub Dir/File.txt", "Dir/Sub Dir/file.txt"}},
+			want: map[string]string{
+				"dir/":                 "",
+				"dir/Other.txt":        "Dir/Other.txt",
+				"dir/sub-dir/":         "",
+				"dir/sub-dir/file.txt": "Dir/Sub Dir/File.txt",
+			},
+		},
+		{
+			name:    "target in a new directory",
+			tree:    []string{"a.jpg"},
+			renames: [][2]string{{"a.jpg", "photos/2023/a.jpg"}},
+			want:    map[string]string{"photos/": "", "photos/2023/": "", "photos/2023/a.jpg": "a.jpg"},
+		},
+		{
+			name:    "failure rolls back",
+			tree:    []string{"Dir/Sub/File.txt", "a", "b", "c", "taken"},
+			renames: [][2]string{{"Dir/Sub/File.txt", "Dir/Sub/file.txt"}, {"Dir/Sub", "Dir/sub"}, {"Dir", "dir"}, {"a", "b"}, {"b", "a"}, {"c", "taken"}},
+			want: map[string]string{
+				"Dir/":             "",
+				"Dir/Sub/":         "",
+				"Dir/Sub/File.txt": "Dir/Sub/File.txt",
+				"a":                "a",
+				"b":                "b",
+				"c":                "c",
+				"taken":            "taken",
+			},
+			wantErr: ErrRolledBack,
+		},
+		{
+			name:    "failure removes created directories",
+			tree:    []string{"a.jpg", "b.jpg", "taken"},
+			renames: [][2]string{{"a.jpg", "photos/2023/a.jpg"}, {"b.jpg", "taken"}},
+			want:    map[string]string{"a.jpg": "a.jpg", "b.jpg": "b.jpg", "taken": "taken"},
+			wantErr: ErrRolledBack,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tt.tree)
+			plan := Plan{Root: root}
+			for _, rename := range tt.renames {
+				source := filepath.Join(root, filepath.FromSlash(rename[0]))
+				info, errStat := os.Stat(source)
+				if errStat != nil {
+					t.Fatal(errStat)
+				}
+				plan.Operations = append(plan.Operations, Operation{
+					Source: source,
+					Target: filepath.Join(root, filepath.FromSlash(rename[1])),
+					IsDir:  info.IsDir(),
+				})
+			}
+			_, _, errApply := plan.apply(func() {})
+			if !errors.Is(errApply, tt.wantErr) {
+				t.Fatalf("apply() error = %v, want %v", errApply, tt.wantErr)
+			}
+			if got := readTree(t, root); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tree after apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanFinalTargets(t *testing.T) {
+	plan := Plan{Operations: []Operation{
+		{Source: "/r/Dir", Target: "/r/dir", IsDir: true},
+		{Source: "/r/Dir/Sub", Target: "/r/Dir/sub", IsDir: true},
+		{Source: "/r/Dir/Sub/File", Target: "/r/Dir/Sub/file"},
+	}}
+	want := []string{"/r/dir", "/r/dir/sub", "/r/dir/sub/file"}
+	if got := plan.finalTargets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("finalTargets() = %v, want %v", got, want)
+	}
+}
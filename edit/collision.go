@@ -0,0 +1,183 @@
+package edit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	CollisionAbort   = "abort"
+	CollisionSkip    = "skip"
+	CollisionCounter = "counter"
+	CollisionHash    = "hash"
+)
+
+var (
+	ErrUnknownCollisionPolicy = errors.New("unknown collision policy")
+	ErrTargetExists           = errors.New("target already exists")
+)
+
+type CollisionError struct {
+	With     string
+	CaseOnly bool
+}
+
+func (e *CollisionError) Error() string {
+	if e.CaseOnly {
+		return "collides with " + filepath.Base(e.With) + " on case-insensitive volumes"
+	}
+	return "collides with " + filepath.Base(e.With)
+}
+
+func ValidCollisionPolicy(policy string) error {
+	switch policy {
+	case CollisionAbort, CollisionSkip, CollisionCounter, CollisionHash:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownCollisionPolicy, policy)
+}
+
+// collisionKey folds case so names that only collide on case-insensitive
+// volumes are detected as well.
+func collisionKey(path string) string {
+	return filepath.Join(filepath.Dir(path), strings.ToLower(filepath.Base(path)))
+}
+
+// resolveCollisions finds every planned name that is already taken, either by
+// an entry that is not being renamed or by an earlier entry in the plan, and
+// applies the policy to it. Skipping an entry frees its target but keeps its
+// current name taken, which can cause new collisions, so the pass is repeated
+// until nothing changes.
+func (p *Plan) resolveCollisions(policy string) error {
+	for {
+		restart, err := p.resolveCollisionsPass(policy)
+		if err != nil || !restart {
+			return err
+		}
+	}
+}
+
+func (p *Plan) resolveCollisionsPass(policy string) (bool, error) {
+	claimed, errClaimed := p.staticClaims()
+	if errClaimed != nil {
+		return false, errClaimed
+	}
+	for i := range p.Operations {
+		op := &p.Operations[i]
+		if op.Err != nil || op.Unchanged() {
+			continue
+		}
+		holder, taken := claimed[collisionKey(op.Target)]
+		if !taken {
+			claimed[collisionKey(op.Target)] = claim{by: op.Source, name: op.Target}
+			continue
+		}
+		collision := &CollisionError{With: holder.by, CaseOnly: filepath.Base(holder.name) != filepath.Base(op.Target)}
+		op.Collision = collision
+		switch policy {
+		case CollisionAbort:
+			op.Err = collision
+		case CollisionSkip:
+			op.Target = op.Source
+			return true, nil
+		case CollisionCounter, CollisionHash:
+			target, errFree := p.freeName(op, policy, claimed)
+			if errFree != nil {
+				return false, errFree
+			}
+			op.Target = target
+			claimed[collisionKey(op.Target)] = claim{by: op.Source, name: op.Target}
+		}
+	}
+	return false, nil
+}
+
+// claim records which entry holds a name, by is the current path of the
+// entry and name is the path it ends up with.
+type claim struct {
+	by   string
+	name string
+}
+
+// staticClaims returns the names that are taken regardless of the plan: entries
+// that are not renamed and the sources of entries that keep their name.
+func (p *Plan) staticClaims() (map[string]claim, error) {
+	moving := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, op := range p.Operations {
+		dirs[filepath.Dir(op.Source)] = true
+		dirs[filepath.Dir(op.Target)] = true
+		if op.Err == nil && !op.Unchanged() {
+			moving[op.Source] = true
+		}
+	}
+	claimed := map[string]claim{}
+	for dir := range dirs {
+		info, errStat := os.Stat(dir)
+		if errors.Is(errStat, fs.ErrNotExist) || errStat == nil && !info.IsDir() {
+			continue
+		}
+		entries, errRead := os.ReadDir(dir)
+		if errRead != nil {
+			return nil, errRead
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !moving[path] {
+				claimed[collisionKey(path)] = claim{by: path, name: path}
+			}
+		}
+	}
+	return claimed, nil
+}
+
+func (p *Plan) freeName(op *Operation, policy string, claimed map[string]claim) (string, error) {
+	stem, ext := splitName(filepath.Base(op.Target), op.IsDir)
+	dir := filepath.Dir(op.Target)
+	if policy == CollisionHash {
+		sum, errHash := hashSource(op.Source, op.IsDir)
+		if errHash != nil {
+			return "", errHash
+		}
+		stem += "-" + sum[:8]
+		candidate := filepath.Join(dir, stem+ext)
+		if _, taken := claimed[collisionKey(candidate)]; !taken {
+			return candidate, nil
+		}
+	}
+	for n := 2; ; n++ {
+		candidate := filepath.Join(dir, stem+"-"+strconv.Itoa(n)+ext)
+		if _, taken := claimed[collisionKey(candidate)]; !taken {
+			return candidate, nil
+		}
+	}
+}
+
+// hashSource hashes the content of a file, directories are hashed by their
+// path since they have no content of their own.
+func hashSource(path string, isDir bool) (string, error) {
+	h := sha256.New()
+	if isDir {
+		h.Write([]byte(path))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return "", errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if _, errCopy := io.Copy(h, f); errCopy != nil {
+		return "", errCopy
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
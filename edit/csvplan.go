@@ -0,0 +1,150 @@
+package edit
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	csvColumnOld   = "old"
+	csvColumnNew   = "new"
+	csvColumnType  = "type"
+	csvColumnError = "error"
+)
+
+var (
+	ErrCSVMissingColumn   = errors.New("plan file is missing a column")
+	ErrSourceMissing      = errors.New("source doesn't exist")
+	ErrSourceDuplicate    = errors.New("source appears more than once")
+	ErrSourceOutsideRoot  = errors.New("source is outside of the target directory")
+	ErrTargetPathRequired = errors.New("new path is empty")
+)
+
+// writePlanCSV writes the plan with paths relative to the plan root so the
+// file can be edited in a spreadsheet and applied on another machine.
+func writePlanCSV(plan Plan, path string) error {
+	f, errCreate := os.Create(path)
+	if errCreate != nil {
+		return errCreate
+	}
+	writer := csv.NewWriter(f)
+	_ = writer.Write([]string{csvColumnOld, csvColumnNew, csvColumnType, csvColumnError})
+	for _, op := range plan.Operations {
+		opType := "file"
+		if op.IsDir {
+			opType = "directory"
+		}
+		errText := ""
+		if op.Err != nil {
+			errText = op.Err.Error()
+		}
+		_ = writer.Write([]string{
+			filepath.ToSlash(plan.relative(op.Source)),
+			filepath.ToSlash(plan.relative(op.Target)),
+			opType,
+			errText,
+		})
+	}
+	writer.Flush()
+	if errWrite := writer.Error(); errWrite != nil {
+		_ = f.Close()
+		return errWrite
+	}
+	return f.Close()
+}
+
+// readPlanCSV builds a plan from a mapping file with old and new columns,
+// other columns are ignored. Paths are relative to root. Every row is
+// validated, failures are recorded on the operation like any other plan.
+func readPlanCSV(root, path string, protected []string) (Plan, error) {
+	plan := Plan{Root: root, protectedPatterns: protected}
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return plan, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, errHeader := reader.Read()
+	if errHeader != nil {
+		return plan, fmt.Errorf("reading plan header: %w", errHeader)
+	}
+	oldColumn, newColumn := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case csvColumnOld:
+			oldColumn = i
+		case csvColumnNew:
+			newColumn = i
+		}
+	}
+	if oldColumn < 0 || newColumn < 0 {
+		return plan, fmt.Errorf("%w: expected %q and %q", ErrCSVMissingColumn, csvColumnOld, csvColumnNew)
+	}
+
+	var files, dirs []Operation
+	seen := map[string]bool{}
+	for {
+		record, errRead := reader.Read()
+		if errors.Is(errRead, io.EOF) {
+			break
+		}
+		if errRead != nil {
+			return plan, errRead
+		}
+		if len(record) <= oldColumn || len(record) <= newColumn || strings.TrimSpace(record[oldColumn]) == "" {
+			continue
+		}
+		op := mappingOperation(plan, record[oldColumn], strings.TrimSpace(record[newColumn]))
+		if seen[op.Source] && op.Err == nil {
+			op.Err = ErrSourceDuplicate
+		}
+		seen[op.Source] = true
+		if op.IsDir {
+			dirs = append(dirs, op)
+		} else {
+			files = append(files, op)
+		}
+	}
+	plan.Operations = append(files, dirs...)
+	return plan, nil
+}
+
+func mappingOperation(plan Plan, oldRel, newRel string) Operation {
+	root := plan.Root
+	source := filepath.Join(root, filepath.FromSlash(oldRel))
+	op := Operation{Source: source, Target: source}
+	within, errRel := filepath.Rel(root, source)
+	if errRel != nil || within == "." || within == ".." || strings.HasPrefix(within, ".."+string(filepath.Separator)) {
+		op.Err = ErrSourceOutsideRoot
+		return op
+	}
+	if isProtected(plan.protectedPatterns, root, source) {
+		op.Err = ErrProtected
+		return op
+	}
+	info, errStat := os.Lstat(source)
+	if errStat != nil {
+		op.Err = ErrSourceMissing
+		return op
+	}
+	op.IsDir = info.IsDir()
+	if newRel == "" {
+		op.Err = ErrTargetPathRequired
+		return op
+	}
+	target, errTarget := editedTarget(plan, op, newRel)
+	if errTarget != nil {
+		op.Err = errTarget
+		return op
+	}
+	op.Target = target
+	return op
+}
@@ -7,6 +7,8 @@ func Command() *cli.Command {
 		Name: "edit",
 		Subcommands: []*cli.Command{
 			subCommandRename,
+			subCommandFixExtensions,
+			subCommandOrganize,
 		},
 	}
 }
@@ -0,0 +1,161 @@
+package edit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrEditorLineMissing   = errors.New("line was removed from the editor file")
+	ErrEditorLineDuplicate = errors.New("line number appears more than once in the editor file")
+	ErrEditorLineInvalid   = errors.New("line in the editor file isn't a number followed by a path")
+	ErrPathEscapes         = errors.New("new path is outside of the target directory")
+	ErrDirectoryMoved      = errors.New("directories can only be renamed, not moved")
+	ErrParentMissing       = errors.New("new parent directory doesn't exist")
+)
+
+const editorHeader = `# Edit the paths below and save the file to rename them. Each line starts with
+# a number that ties it to the original entry, don't change or remove it.
+# Paths are relative to the target directory. Files can be moved into other
+# existing directories inside the target, directories can only be renamed.
+# Lines starting with # are ignored. Close the editor without changes to abort.
+`
+
+// editor returns the command used to edit files, taken from $VISUAL or
+// $EDITOR. Both may contain arguments such as "code --wait".
+func editor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// editPlan writes the sources of the plan to a temporary file, opens it in
+// the user's editor and turns the edited lines into the targets of the plan.
+func editPlan(plan Plan) (Plan, error) {
+	tmp, errCreate := os.CreateTemp("", "dev-tools-rename-*.txt")
+	if errCreate != nil {
+		return plan, errCreate
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	writer := bufio.NewWriter(tmp)
+	_, _ = writer.WriteString(editorHeader)
+	for i, op := range plan.Operations {
+		_, _ = fmt.Fprintf(writer, "%d\t%s\n", i+1, filepath.ToSlash(plan.relative(op.Source)))
+	}
+	if errFlush := writer.Flush(); errFlush != nil {
+		return plan, errFlush
+	}
+	if errClose := tmp.Close(); errClose != nil {
+		return plan, errClose
+	}
+
+	command := editor()
+	cmd := exec.Command(command[0], append(command[1:], tmp.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if errRun := cmd.Run(); errRun != nil {
+		return plan, fmt.Errorf("running editor %s: %w", command[0], errRun)
+	}
+
+	edited, errRead := readEditorFile(tmp.Name(), len(plan.Operations))
+	if errRead != nil {
+		return plan, errRead
+	}
+	for i := range plan.Operations {
+		op := &plan.Operations[i]
+		target, errTarget := editedTarget(plan, *op, edited[i])
+		if errTarget != nil {
+			op.Err = errTarget
+			continue
+		}
+		op.Target = target
+	}
+	return plan, nil
+}
+
+// readEditorFile returns the edited relative paths indexed by their original
+// position. Every line has to be present exactly once.
+func readEditorFile(path string, count int) ([]string, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	edited := make([]string, count)
+	seen := make([]bool, count)
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		number, rel, found := strings.Cut(line, "\t")
+		index, errIndex := strconv.Atoi(strings.TrimSpace(number))
+		if !found || errIndex != nil || index < 1 || index > count {
+			return nil, fmt.Errorf("%w: line %d: %q", ErrEditorLineInvalid, lineNumber, line)
+		}
+		if seen[index-1] {
+			return nil, fmt.Errorf("%w: %d", ErrEditorLineDuplicate, index)
+		}
+		seen[index-1] = true
+		edited[index-1] = rel
+	}
+	if errScan := scanner.Err(); errScan != nil {
+		return nil, errScan
+	}
+	var missing []string
+	for i, ok := range seen {
+		if !ok {
+			missing = append(missing, strconv.Itoa(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEditorLineMissing, strings.Join(missing, ", "))
+	}
+	return edited, nil
+}
+
+func editedTarget(plan Plan, op Operation, rel string) (string, error) {
+	root := plan.Root
+	if rel == "" {
+		return "", ErrEmptyName
+	}
+	if filepath.IsAbs(rel) {
+		return "", ErrPathEscapes
+	}
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	within, errRel := filepath.Rel(root, target)
+	if errRel != nil || within == "." || within == ".." || strings.HasPrefix(within, ".."+string(filepath.Separator)) {
+		return "", ErrPathEscapes
+	}
+	if isProtected(plan.protectedPatterns, root, target) {
+		return "", ErrProtected
+	}
+	if filepath.Dir(target) == filepath.Dir(op.Source) {
+		return target, nil
+	}
+	if op.IsDir {
+		return "", ErrDirectoryMoved
+	}
+	info, errStat := os.Stat(filepath.Dir(target))
+	if errStat != nil || !info.IsDir() {
+		return "", ErrParentMissing
+	}
+	return target, nil
+}
@@ -0,0 +1,104 @@
+package edit
+
+import (
+	"os"
+	"strings"
+
+	"github.com/h2non/filetype"
+
+	"DevToolsCLI/file"
+)
+
+// extensionGroups are extensions that are interchangeable for the type the
+// content sniffing detects. Camera raw files are TIFF based, office files and
+// app packages are zip archives and most video, audio and HEIF/AVIF images
+// share the ISO base media format, so they must not be renamed to .tif, .zip
+// or .mp4.
+var extensionGroups = [][]string{
+	{"jpg", "jpeg", "jpe", "jfif", "mpo"},
+	{"tif", "tiff", "cr2", "dng", "nef", "nrw", "arw", "srf", "sr2", "orf", "rw2", "pef", "srw", "3fr", "erf", "kdc", "dcr", "mos", "iiq"},
+	{"mp4", "m4v", "m4a", "m4b", "m4p", "f4v", "mov", "qt", "3gp", "3g2", "heif", "heic", "hif", "avif", "cr3"},
+	{"zip", "jar", "war", "ear", "apk", "aab", "ipa", "xpi", "crx", "whl", "nupkg", "vsix", "cbz", "kmz", "odt", "ods", "odp", "odg", "pages", "numbers", "key", "sketch", "3mf", "usdz", "epub", "docx", "xlsx", "pptx"},
+	{"doc", "xls", "ppt", "msi", "msg"},
+	{"exe", "dll", "sys", "scr", "ocx", "cpl", "efi", "com"},
+	{"elf", "so", "o", "ko", "bin", "axf", "out", "prx"},
+	{"ar", "a", "lib", "deb"},
+	{"gz", "tgz"},
+	{"bz2", "tbz2", "tbz"},
+	{"xz", "txz"},
+	{"ogg", "oga", "ogv", "opus", "spx"},
+	{"mkv", "mka", "mks", "webm"},
+	{"mpg", "mpeg", "m2v", "vob"},
+	{"ps", "eps"},
+	{"ico", "cur"},
+	{"aiff", "aif", "aifc"},
+	{"mid", "midi"},
+}
+
+// extensionlessTypes are never renamed, executables usually don't have an
+// extension and libraries use many different ones.
+var extensionlessTypes = map[string]bool{"elf": true, "macho": true, "exe": true, "ar": true}
+
+func sameExtension(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	for _, group := range extensionGroups {
+		if containsString(group, a) && containsString(group, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// detectedExtension returns the extension of the type detected from the
+// content of path, or an empty string if the type is unknown.
+func detectedExtension(path string) string {
+	info, errStat := os.Stat(path)
+	if errStat != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return ""
+	}
+	fType, errType := file.GetFileTypeFromFilePath(path)
+	if errType != nil || fType == filetype.Unknown {
+		return ""
+	}
+	return fType.Extension
+}
+
+// extensionRule replaces an extension that doesn't match the content of the
+// file with the canonical one for the detected type. Files of an unknown type
+// are left alone.
+type extensionRule struct{}
+
+func (extensionRule) Apply(name string, t Target) string {
+	if t.IsDir {
+		return name
+	}
+	detected := detectedExtension(t.Path)
+	if detected == "" {
+		return name
+	}
+	stem, ext := splitName(name, false)
+	current := strings.TrimPrefix(ext, ".")
+	if current != "" && sameExtension(current, detected) {
+		return name
+	}
+	if extensionlessTypes[detected] {
+		return name
+	}
+	return stem + "." + detected
+}
+
+func (extensionRule) String() string {
+	return "fix-extension"
+}
@@ -0,0 +1,103 @@
+package edit
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+	"DevToolsCLI/prompt"
+)
+
+var subCommandFixExtensions = &cli.Command{
+	Name:        "fix-extensions",
+	Description: "Rename files whose extension doesn't match their content to the extension of the detected type.",
+	Flags: append(append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "target",
+			Required:    false,
+			DefaultText: "the directory with the files you want to fix",
+		},
+		&cli.BoolFlag{
+			Name:     "recursive",
+			Required: false,
+			Usage:    "recursively fix files in subdirectories",
+			Value:    false,
+		},
+	}, planFlags("rename", "only show the files with a wrong extension without renaming them")...), file.FilterFlags()...),
+	Action: FixExtensions,
+}
+
+func FixExtensions(c *cli.Context) error {
+	settings, errSettings := planSettingsFromContext(c)
+	if errSettings != nil {
+		return errSettings
+	}
+	targetDirectory, filter, collisionPolicy := settings.Target, settings.Filter, settings.CollisionPolicy
+	fullPlan, errPlan := buildPlan(targetDirectory, PlanOptions{
+		Recursive:       c.Bool("recursive"),
+		Filter:          filter,
+		Protected:       settings.Protected,
+		Pipeline:        Pipeline{extensionRule{}},
+		CollisionPolicy: collisionPolicy,
+	})
+	if errPlan != nil {
+		log.Error().Err(errPlan).Msg("Failed to plan renames")
+		return errPlan
+	}
+	plan := fullPlan.changes()
+	settings.useGit(&plan, findGitRepository(targetDirectory))
+
+	if settings.DryRun {
+		return showPlan(plan, settings.Format)
+	}
+	if len(plan.Operations) == 0 {
+		pterm.Info.Printfln("All %d files have the right extension.", len(fullPlan.Operations))
+		return nil
+	}
+
+	pterm.DefaultSection.Println("Fixing extensions in " + pterm.LightGreen(targetDirectory))
+	errTable := pterm.DefaultTable.WithData(pterm.TableData{
+		{"Target directory", targetDirectory},
+		{"Recursively", fmt.Sprintf("%t", c.Bool("recursive"))},
+		{"On collision", collisionPolicy},
+		{"Filters", filter.String()},
+		{"Checked entries", strconv.Itoa(len(fullPlan.Operations))},
+		{"Wrong extensions", strconv.Itoa(plan.Changed())},
+		{"Failing renames", strconv.Itoa(len(plan.Failed()))},
+		{"Protected", plan.protectedDescription()},
+		{"Git", plan.gitDescription()},
+	}).Render()
+	if errTable != nil {
+		log.Error().Err(errTable).Msg("Failed to render table")
+		return errTable
+	}
+	if len(plan.Failed()) > 0 {
+		pterm.Error.Println("Some renames would fail, run with --dry-run to see all of them.")
+		return ErrPlanHasFailures
+	}
+	confirmed, errAsk := prompt.Confirm(c, "Are you sure you want to fix the extensions in "+pterm.LightGreen(targetDirectory)+"?", false)
+	if errAsk != nil {
+		log.Error().Err(errAsk).Msg("Failed to get ask for confirmation")
+		return errAsk
+	}
+	if !confirmed {
+		return nil
+	}
+
+	_, errApply := applyPlan(plan, "fix-extensions")
+	if errApply != nil {
+		return errApply
+	}
+	pterm.DefaultSection.Println("Fixed extensions")
+	errReport := plan.RenderTable()
+	if errReport != nil {
+		log.Error().Err(errReport).Msg("Failed to render table")
+		return errReport
+	}
+	pterm.Success.Printfln("Fixed the extension of %d files.", plan.Changed())
+	return nil
+}
@@ -0,0 +1,114 @@
+package edit
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+)
+
+// planFlags returns the flags shared by the commands that plan renames and
+// apply them through a journal. verb is what the command does to a file, e.g.
+// rename or move.
+func planFlags(verb, dryRunUsage string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "on-collision",
+			Required: false,
+			Usage:    "what to do when two files end up with the same name: abort, skip, counter or hash",
+			Value:    CollisionAbort,
+		},
+		&cli.StringSliceFlag{
+			Name:     "protect",
+			Required: false,
+			Usage:    "never " + verb + " or walk into paths matching this glob, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:     "no-default-protect",
+			Required: false,
+			Usage:    "don't protect .git, .svn, .hg, node_modules and system directories by default",
+			Value:    false,
+		},
+		&cli.BoolFlag{
+			Name:     "force",
+			Required: false,
+			Usage:    "allow " + verb + "s in /, the home directory, the root of a repository or a protected directory",
+			Value:    false,
+		},
+		&cli.BoolFlag{
+			Name:     "no-git",
+			Required: false,
+			Usage:    verb + " on disk only, even when the target is inside a git working tree",
+			Value:    false,
+		},
+		&cli.BoolFlag{
+			Name:     "dry-run",
+			Required: false,
+			Usage:    dryRunUsage,
+			Value:    false,
+		},
+		&cli.StringFlag{
+			Name:     "format",
+			Required: false,
+			Usage:    "output format of the dry run, table or json",
+			Value:    formatTable,
+		},
+	}
+}
+
+// planSettings holds the checked values of the flags from planFlags, the
+// target and the filters.
+type planSettings struct {
+	Target          string
+	Format          string
+	DryRun          bool
+	CollisionPolicy string
+	Protected       []string
+	Filter          file.Filter
+	NoGit           bool
+}
+
+func validFormat(format string) error {
+	if format != formatTable && format != formatJSON {
+		return fmt.Errorf("unknown format %q, expected %s or %s", format, formatTable, formatJSON)
+	}
+	return nil
+}
+
+// planSettingsFromContext checks the shared flags and refuses protected
+// targets unless --force is given.
+func planSettingsFromContext(c *cli.Context) (planSettings, error) {
+	settings := planSettings{
+		Target:          c.String("target"),
+		Format:          c.String("format"),
+		DryRun:          c.Bool("dry-run"),
+		CollisionPolicy: c.String("on-collision"),
+		Protected:       protectedPatterns(c.StringSlice("protect"), !c.Bool("no-default-protect")),
+		NoGit:           c.Bool("no-git"),
+	}
+	if errFormat := validFormat(settings.Format); errFormat != nil {
+		return settings, errFormat
+	}
+	if settings.Target == "" {
+		return settings, ErrTargetRequired
+	}
+	if !c.Bool("force") {
+		if errTarget := checkTarget(settings.Target, settings.Protected); errTarget != nil {
+			return settings, errTarget
+		}
+	}
+	if errPolicy := ValidCollisionPolicy(settings.CollisionPolicy); errPolicy != nil {
+		return settings, errPolicy
+	}
+	settings.Filter = file.FilterFromContext(c)
+	return settings, nil
+}
+
+// useGit lets the plan rename tracked entries with git mv unless --no-git is
+// given.
+func (s planSettings) useGit(plan *Plan, repository *gitRepository) {
+	if !s.NoGit {
+		plan.git = repository
+	}
+}
@@ -0,0 +1,208 @@
+package edit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUncommittedChanges = errors.New("has uncommitted changes")
+
+// gitRepository is the working tree a plan root belongs to. Tracked entries
+// are renamed with git mv so the index records a rename instead of a delete
+// and an add, untracked entries are renamed on disk only.
+type gitRepository struct {
+	TopLevel string
+
+	// index holds the tracked files and the directories above them, relative
+	// to TopLevel. It is read on the first rename and kept up to date after.
+	index map[string]bool
+}
+
+// findGitRepository returns the working tree containing directory, or nil if
+// it isn't inside one or git isn't installed.
+func findGitRepository(directory string) *gitRepository {
+	if _, errLook := exec.LookPath("git"); errLook != nil {
+		return nil
+	}
+	out, errRun := exec.Command("git", "-C", directory, "rev-parse", "--show-toplevel").Output()
+	if errRun != nil {
+		return nil
+	}
+	topLevel := strings.TrimSpace(string(out))
+	if topLevel == "" {
+		return nil
+	}
+	return &gitRepository{TopLevel: filepath.FromSlash(topLevel)}
+}
+
+func (g *gitRepository) run(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.TopLevel}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, errRun := cmd.Output()
+	if errRun != nil {
+		return out, fmt.Errorf("git %s: %w: %s", args[0], errRun, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (g *gitRepository) loadIndex() error {
+	out, errRun := g.run("ls-files", "-z", "--full-name")
+	if errRun != nil {
+		return errRun
+	}
+	g.index = map[string]bool{}
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry != "" {
+			g.addIndexed(entry)
+		}
+	}
+	return nil
+}
+
+func (g *gitRepository) addIndexed(entry string) {
+	for entry != "." && !g.index[entry] {
+		g.index[entry] = true
+		entry = path.Dir(entry)
+	}
+}
+
+// indexKey returns p relative to TopLevel the way ls-files lists it. The
+// parent of p is resolved when p is reached through a symlink.
+func (g *gitRepository) indexKey(p string) (string, bool) {
+	absolute, errAbs := filepath.Abs(p)
+	if errAbs != nil {
+		return "", false
+	}
+	rel, errRel := filepath.Rel(g.TopLevel, absolute)
+	if errRel != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		parent, errEval := filepath.EvalSymlinks(filepath.Dir(absolute))
+		if errEval != nil {
+			return "", false
+		}
+		rel, errRel = filepath.Rel(g.TopLevel, filepath.Join(parent, filepath.Base(absolute)))
+		if errRel != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// moveIndexed updates the index after source, and everything below it, was
+// moved to target by git mv.
+func (g *gitRepository) moveIndexed(source, target string) {
+	var moved []string
+	for entry := range g.index {
+		if entry == source || strings.HasPrefix(entry, source+"/") {
+			moved = append(moved, entry)
+		}
+	}
+	for _, entry := range moved {
+		delete(g.index, entry)
+	}
+	for _, entry := range moved {
+		g.addIndexed(target + entry[len(source):])
+	}
+}
+
+// rename moves source to target, through git mv when source, or anything
+// below it, is tracked. Directories with untracked files inside are moved as
+// a whole by git mv.
+func (g *gitRepository) rename(source, target string) error {
+	if g == nil {
+		return os.Rename(source, target)
+	}
+	if g.index == nil {
+		if errIndex := g.loadIndex(); errIndex != nil {
+			return errIndex
+		}
+	}
+	sourceKey, inTree := g.indexKey(source)
+	if !inTree || !g.index[sourceKey] {
+		return os.Rename(source, target)
+	}
+	if _, errMove := g.run("mv", "--", source, target); errMove != nil {
+		return errMove
+	}
+	if targetKey, ok := g.indexKey(target); ok {
+		g.moveIndexed(sourceKey, targetKey)
+	}
+	return nil
+}
+
+// dirty returns the absolute paths of tracked files with staged or unstaged
+// changes.
+func (g *gitRepository) dirty() ([]string, error) {
+	out, errRun := g.run("status", "--porcelain=v1", "-z", "--untracked-files=no")
+	if errRun != nil {
+		return nil, errRun
+	}
+	var paths []string
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		entry := fields[i]
+		if len(entry) < 4 {
+			continue
+		}
+		paths = append(paths, filepath.Join(g.TopLevel, filepath.FromSlash(entry[3:])))
+		// Renames and copies are followed by the original path.
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return paths, nil
+}
+
+// refuseDirty fails every operation whose source has uncommitted changes in
+// repository, for directories when any tracked file inside them has.
+func (p *Plan) refuseDirty(repository *gitRepository) error {
+	if repository == nil {
+		return nil
+	}
+	dirty, errDirty := repository.dirty()
+	if errDirty != nil {
+		return errDirty
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	root, errEval := filepath.EvalSymlinks(p.Root)
+	if errEval != nil {
+		return errEval
+	}
+	root, errAbs := filepath.Abs(root)
+	if errAbs != nil {
+		return errAbs
+	}
+	for i := range p.Operations {
+		op := &p.Operations[i]
+		if op.Err != nil || op.Unchanged() {
+			continue
+		}
+		rel, errRel := filepath.Rel(p.Root, op.Source)
+		if errRel != nil {
+			continue
+		}
+		source := filepath.Join(root, rel)
+		for _, path := range dirty {
+			if path == source || (op.IsDir && strings.HasPrefix(path, source+string(filepath.Separator))) {
+				op.Err = ErrUncommittedChanges
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (p Plan) gitDescription() string {
+	if p.git == nil {
+		return "no"
+	}
+	return "yes, tracked files are renamed with git mv"
+}
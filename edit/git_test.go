@@ -0,0 +1,46 @@
+package edit
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitRepositoryRename(t *testing.T) {
+	if _, errLook := exec.LookPath("git"); errLook != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	writeTree(t, root, []string{"Dir/Sub/File.txt", "Dir/Other.txt", "A.txt"})
+	repository := &gitRepository{TopLevel: root}
+	for _, args := range [][]string{{"init", "-q"}, {"add", "."}} {
+		if _, errRun := repository.run(args...); errRun != nil {
+			t.Fatal(errRun)
+		}
+	}
+	writeTree(t, root, []string{"Dir/Untracked.txt", "B.txt"})
+	plan := Plan{Root: root, git: findGitRepository(root)}
+	for _, rename := range [][2]string{{"Dir/Sub/File.txt", "Dir/Sub/file.txt"}, {"Dir/Sub", "Dir/sub"}, {"Dir", "dir"}, {"A.txt", "a.txt"}, {"B.txt", "b.txt"}} {
+		plan.Operations = append(plan.Operations, Operation{
+			Source: filepath.Join(root, filepath.FromSlash(rename[0])),
+			Target: filepath.Join(root, filepath.FromSlash(rename[1])),
+			IsDir:  !strings.Contains(rename[0], "."),
+		})
+	}
+	if _, _, errApply := plan.apply(func() {}); errApply != nil {
+		t.Fatal(errApply)
+	}
+	out, errRun := repository.run("ls-files")
+	if errRun != nil {
+		t.Fatal(errRun)
+	}
+	want := []string{"a.txt", "dir/Other.txt", "dir/sub/file.txt"}
+	if got := strings.Fields(string(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("tracked files after apply() = %v, want %v", got, want)
+	}
+	if got := readTree(t, root)["b.txt"]; got != "B.txt" {
+		t.Errorf("untracked file after apply() = %q, want %q", got, "B.txt")
+	}
+}
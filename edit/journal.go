@@ -0,0 +1,300 @@
+package edit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"DevToolsCLI/file"
+)
+
+const (
+	journalExtension       = ".json"
+	journalUndoneExtension = ".undone.json"
+	journalTimeFormat      = "20060102-150405.000000000"
+)
+
+var (
+	ErrNoJournal       = errors.New("no rename journal found")
+	ErrJournalMismatch = errors.New("files no longer match the journal")
+)
+
+type Journal struct {
+	Created time.Time      `json:"created"`
+	Command string         `json:"command"`
+	Root    string         `json:"root"`
+	Entries []JournalEntry `json:"entries"`
+	// Directories were created by the run and are removed again by an undo.
+	Directories []string `json:"directories,omitempty"`
+	// Rewrites are the references rewritten after the renames, an undo
+	// restores them before renaming back.
+	Rewrites []JournalRewrite `json:"rewrites,omitempty"`
+}
+
+type JournalEntry struct {
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	IsDir bool   `json:"isDir"`
+}
+
+// JournalRewrite lists the references rewritten in one file. File is where
+// the file is after the renames.
+type JournalRewrite struct {
+	File  string        `json:"file"`
+	Edits []JournalEdit `json:"edits"`
+}
+
+// JournalEdit is a reference that was replaced by New, which starts at
+// Offset in the rewritten file.
+type JournalEdit struct {
+	Offset int    `json:"offset"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+func journalDirectory() (string, error) {
+	dir, errState := file.StateDirectory()
+	if errState != nil {
+		return "", errState
+	}
+	return filepath.Join(dir, "journals"), nil
+}
+
+func newJournal(command, root string, completed []Operation, created []string) (Journal, error) {
+	absoluteRoot, errAbs := filepath.Abs(root)
+	if errAbs != nil {
+		return Journal{}, errAbs
+	}
+	j := Journal{
+		Created: time.Now(),
+		Command: command,
+		Root:    absoluteRoot,
+	}
+	for _, op := range completed {
+		oldPath, errOld := filepath.Abs(op.Source)
+		if errOld != nil {
+			return j, errOld
+		}
+		newPath, errNew := filepath.Abs(op.Target)
+		if errNew != nil {
+			return j, errNew
+		}
+		j.Entries = append(j.Entries, JournalEntry{Old: oldPath, New: newPath, IsDir: op.IsDir})
+	}
+	for _, dir := range created {
+		absoluteDir, errDir := filepath.Abs(dir)
+		if errDir != nil {
+			return j, errDir
+		}
+		j.Directories = append(j.Directories, absoluteDir)
+	}
+	return j, nil
+}
+
+// addRewrites records the rewritten references, grouped by file in the order
+// they appear.
+func (j *Journal) addRewrites(references []Reference) {
+	index := map[string]int{}
+	for _, reference := range references {
+		i, found := index[reference.File]
+		if !found {
+			i = len(j.Rewrites)
+			index[reference.File] = i
+			j.Rewrites = append(j.Rewrites, JournalRewrite{File: reference.File})
+		}
+		j.Rewrites[i].Edits = append(j.Rewrites[i].Edits, JournalEdit{Offset: reference.offset, Old: reference.Old, New: reference.New})
+	}
+}
+
+// Save writes the journal to the journal directory and returns its path.
+func (j Journal) Save() (string, error) {
+	dir, errDir := journalDirectory()
+	if errDir != nil {
+		return "", errDir
+	}
+	if errMkdir := os.MkdirAll(dir, 0o755); errMkdir != nil {
+		return "", errMkdir
+	}
+	name := j.Command + "-" + j.Created.Format(journalTimeFormat) + journalExtension
+	path := filepath.Join(dir, name)
+	data, errMarshal := json.MarshalIndent(j, "", "  ")
+	if errMarshal != nil {
+		return "", errMarshal
+	}
+	return path, os.WriteFile(path, data, 0o644)
+}
+
+// findJournal resolves a journal by path or by name inside the journal
+// directory. Without a name the most recent journal that hasn't been undone is
+// returned.
+func findJournal(name string) (string, error) {
+	if name != "" {
+		if _, errStat := os.Stat(name); errStat == nil {
+			return name, nil
+		}
+	}
+	dir, errDir := journalDirectory()
+	if errDir != nil {
+		return "", errDir
+	}
+	if name != "" {
+		path := filepath.Join(dir, name)
+		if !strings.HasSuffix(path, journalExtension) {
+			path += journalExtension
+		}
+		if _, errStat := os.Stat(path); errStat != nil {
+			return "", fmt.Errorf("%w: %s", ErrNoJournal, name)
+		}
+		return path, nil
+	}
+	entries, errRead := os.ReadDir(dir)
+	if errors.Is(errRead, fs.ErrNotExist) {
+		return "", ErrNoJournal
+	}
+	if errRead != nil {
+		return "", errRead
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), journalExtension) && !strings.HasSuffix(entry.Name(), journalUndoneExtension) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", ErrNoJournal
+	}
+	sort.Slice(names, func(i, k int) bool {
+		return journalTime(names[i]).Before(journalTime(names[k]))
+	})
+	return filepath.Join(dir, names[len(names)-1]), nil
+}
+
+func journalTime(name string) time.Time {
+	name = strings.TrimSuffix(name, journalExtension)
+	if len(name) < len(journalTimeFormat) {
+		return time.Time{}
+	}
+	t, _ := time.Parse(journalTimeFormat, name[len(name)-len(journalTimeFormat):])
+	return t
+}
+
+func loadJournal(path string) (Journal, error) {
+	var j Journal
+	data, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return j, errRead
+	}
+	return j, json.Unmarshal(data, &j)
+}
+
+// undoPlan builds a plan that reverses the journal, newest rename first. Every
+// entry has to still be where the journal left it.
+func (j Journal) undoPlan() (Plan, error) {
+	plan := Plan{Root: j.Root, Sequential: true}
+	renamed := map[string]bool{}
+	for _, entry := range j.Entries {
+		renamed[entry.New] = true
+	}
+	// Each entry is looked up where the later entries of the run moved it, like
+	// files inside a renamed directory or moved through a temporary name.
+	var later []Operation
+	current := func(path string) string {
+		mapper := &pathMapper{renames: later}
+		return mapper.forward(path)
+	}
+	var mismatched []string
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		entry := j.Entries[i]
+		if _, errStat := os.Lstat(current(entry.New)); errStat != nil {
+			mismatched = append(mismatched, current(entry.New)+" is missing")
+		}
+		if _, errStat := os.Lstat(current(entry.Old)); errStat == nil && !renamed[entry.Old] && !strings.EqualFold(entry.Old, entry.New) {
+			mismatched = append(mismatched, current(entry.Old)+" already exists")
+		}
+		later = append([]Operation{{Source: entry.Old, Target: entry.New}}, later...)
+		plan.Operations = append(plan.Operations, Operation{
+			Source: entry.New,
+			Target: entry.Old,
+			IsDir:  entry.IsDir,
+		})
+	}
+	if len(mismatched) > 0 {
+		return plan, fmt.Errorf("%w: %s", ErrJournalMismatch, strings.Join(mismatched, ", "))
+	}
+	return plan, nil
+}
+
+type restoredFile struct {
+	path    string
+	content []byte
+}
+
+// undoRewrites returns the content of every rewritten file with the old
+// references put back. Every file has to still hold the new references where
+// the rewrite left them.
+func (j Journal) undoRewrites() ([]restoredFile, error) {
+	var restored []restoredFile
+	var mismatched []string
+	for _, rewrite := range j.Rewrites {
+		content, errRead := os.ReadFile(rewrite.File)
+		if errRead != nil {
+			mismatched = append(mismatched, rewrite.File+" is missing")
+			continue
+		}
+		original, restorable := restoreReferences(content, rewrite.Edits)
+		if !restorable {
+			mismatched = append(mismatched, rewrite.File+" changed since its references were rewritten")
+			continue
+		}
+		restored = append(restored, restoredFile{path: rewrite.File, content: original})
+	}
+	if len(mismatched) > 0 {
+		return restored, fmt.Errorf("%w: %s", ErrJournalMismatch, strings.Join(mismatched, ", "))
+	}
+	return restored, nil
+}
+
+// restoreReferences replaces the new references in content with the old ones.
+func restoreReferences(content []byte, edits []JournalEdit) ([]byte, bool) {
+	var out bytes.Buffer
+	last := 0
+	for _, edit := range edits {
+		end := edit.Offset + len(edit.New)
+		if edit.Offset < last || end > len(content) || string(content[edit.Offset:end]) != edit.New {
+			return nil, false
+		}
+		out.Write(content[last:edit.Offset])
+		out.WriteString(edit.Old)
+		last = end
+	}
+	out.Write(content[last:])
+	return out.Bytes(), true
+}
+
+// restoreFiles writes the restored content back, keeping the permissions.
+func restoreFiles(files []restoredFile) error {
+	for _, f := range files {
+		info, errStat := os.Stat(f.path)
+		if errStat != nil {
+			return errStat
+		}
+		if errWrite := os.WriteFile(f.path, f.content, info.Mode().Perm()); errWrite != nil {
+			return errWrite
+		}
+	}
+	return nil
+}
+
+func markJournalUndone(path string) error {
+	if strings.HasSuffix(path, journalUndoneExtension) {
+		return nil
+	}
+	return os.Rename(path, strings.TrimSuffix(path, journalExtension)+journalUndoneExtension)
+}
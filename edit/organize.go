@@ -0,0 +1,251 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+	"DevToolsCLI/prompt"
+)
+
+const defaultFallbackFolder = "unknown"
+
+var (
+	ErrFolderTemplateRequired = errors.New("--template is required, e.g. {type}/ or {mtime:2006}/{mtime:01}/")
+	ErrInvalidFallbackFolder  = errors.New("--fallback has to be a single directory name")
+)
+
+var subCommandOrganize = &cli.Command{
+	Name:        "organize",
+	Description: "Move files into folders built from a template, e.g. by type, date, camera or part of the name.",
+	Flags: append(append([]cli.Flag{
+		&cli.StringFlag{
+			Name:        "target",
+			Required:    false,
+			DefaultText: "the directory with the files you want to organize",
+		},
+		&cli.StringFlag{
+			Name:     "template",
+			Required: false,
+			Usage:    "folder to move each file into, relative to the target (e.g. {type}/, {mtime:2006}/{mtime:01}/, {exif:Model}/, {1}/)",
+		},
+		&cli.StringFlag{
+			Name:     "match",
+			Required: false,
+			Usage:    "only move files whose name matches this regex, its groups can be used as {1}, {2}, ... in the template",
+		},
+		&cli.StringFlag{
+			Name:     "fallback",
+			Required: false,
+			Usage:    "folder name used when a part of the template is empty, e.g. for files without exif data",
+			Value:    defaultFallbackFolder,
+		},
+		&cli.BoolFlag{
+			Name:     "recursive",
+			Required: false,
+			Usage:    "also organize files in subdirectories",
+			Value:    false,
+		},
+	}, planFlags("move", "only show where files would be moved")...), file.FilterFlags()...),
+	Action: OrganizeFiles,
+}
+
+// folderRule works out the folder a file is moved into. The template is
+// split into one template per directory level, so a level that comes out
+// empty can be replaced with the fallback.
+type folderRule struct {
+	spec     string
+	levels   []templateRule
+	match    *regexp.Regexp
+	fallback string
+}
+
+func newFolderRule(spec, match, fallback string) (*folderRule, error) {
+	if strings.Trim(spec, "/") == "" {
+		return nil, ErrFolderTemplateRequired
+	}
+	if fallback == "" || fallback == "." || fallback == ".." || strings.ContainsAny(fallback, `/\`) {
+		return nil, ErrInvalidFallbackFolder
+	}
+	rule := &folderRule{spec: spec, fallback: fallback}
+	if match != "" {
+		re, errCompile := regexp.Compile(match)
+		if errCompile != nil {
+			return nil, fmt.Errorf("%w: --match %q: %v", ErrInvalidRule, match, errCompile)
+		}
+		rule.match = re
+	}
+	for _, level := range splitTemplateLevels(strings.Trim(spec, "/")) {
+		tpl, errParse := parseTemplateMatch(level, rule.match)
+		if errParse != nil {
+			return nil, errParse
+		}
+		rule.levels = append(rule.levels, tpl)
+	}
+	return rule, nil
+}
+
+// splitTemplateLevels splits a template on the slashes outside of tokens, so
+// layouts such as {mtime:01/02} stay in one piece.
+func splitTemplateLevels(spec string) []string {
+	var levels []string
+	depth, start := 0, 0
+	for i, r := range spec {
+		switch {
+		case r == '{':
+			depth++
+		case r == '}' && depth > 0:
+			depth--
+		case r == '/' && depth == 0:
+			levels = append(levels, spec[start:i])
+			start = i + 1
+		}
+	}
+	return append(levels, spec[start:])
+}
+
+// folder returns the folder, relative to the root, the file is moved into.
+// It returns false for files that don't match the --match pattern.
+func (r *folderRule) folder(t Target) (string, bool) {
+	name := filepath.Base(t.Path)
+	if r.match != nil && !r.match.MatchString(name) {
+		return "", false
+	}
+	segments := make([]string, 0, len(r.levels))
+	for _, level := range r.levels {
+		segment := strings.TrimSpace(level.Apply(name, t))
+		if segment == "" || segment == "." || segment == ".." {
+			segment = r.fallback
+		}
+		segments = append(segments, segment)
+	}
+	return filepath.Join(segments...), true
+}
+
+func organizeOperation(root, path string, index int, opts PlanOptions) Operation {
+	op := Operation{Source: path, Target: path}
+	folder, matched := opts.Folder.folder(Target{Path: path, Index: index})
+	if !matched {
+		return op
+	}
+	op.Target = filepath.Join(root, folder, filepath.Base(path))
+	if isProtected(opts.Protected, root, op.Target) {
+		op.Err = ErrProtected
+		return op
+	}
+	for dir := filepath.Dir(op.Target); dir != root && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		info, errStat := os.Stat(dir)
+		if errStat != nil {
+			continue
+		}
+		if !info.IsDir() {
+			op.Err = ErrParentNotDirectory
+		}
+		break
+	}
+	return op
+}
+
+// newDirectories counts the folders the plan would have to create.
+func (p Plan) newDirectories() int {
+	seen := map[string]bool{}
+	for _, op := range p.Operations {
+		if op.Err != nil || op.Unchanged() {
+			continue
+		}
+		for dir := filepath.Dir(op.Target); !seen[dir]; dir = filepath.Dir(dir) {
+			if _, errStat := os.Stat(dir); errStat == nil {
+				break
+			}
+			seen[dir] = true
+		}
+	}
+	return len(seen)
+}
+
+func OrganizeFiles(c *cli.Context) error {
+	settings, errSettings := planSettingsFromContext(c)
+	if errSettings != nil {
+		return errSettings
+	}
+	targetDirectory, filter, collisionPolicy := settings.Target, settings.Filter, settings.CollisionPolicy
+	folder, errFolder := newFolderRule(c.String("template"), c.String("match"), c.String("fallback"))
+	if errFolder != nil {
+		return errFolder
+	}
+	fullPlan, errPlan := buildPlan(targetDirectory, PlanOptions{
+		Recursive:       c.Bool("recursive"),
+		Filter:          filter,
+		Protected:       settings.Protected,
+		Folder:          folder,
+		CollisionPolicy: collisionPolicy,
+	})
+	if errPlan != nil {
+		log.Error().Err(errPlan).Msg("Failed to plan moves")
+		return errPlan
+	}
+	plan := fullPlan.changes()
+	settings.useGit(&plan, findGitRepository(targetDirectory))
+
+	if settings.DryRun {
+		return showPlan(plan, settings.Format)
+	}
+	if len(plan.Operations) == 0 {
+		pterm.Info.Printfln("All %d files are already organized.", len(fullPlan.Operations))
+		return nil
+	}
+
+	targetDirectoryInfo, errDir := file.GetDirectoryInfo(targetDirectory, filter)
+	if errDir != nil {
+		log.Error().Err(errDir).Msg("Failed to get directory info")
+		return errDir
+	}
+	match := c.String("match")
+	if match == "" {
+		match = "all files"
+	}
+	pterm.DefaultSection.Println("Organizing files in " + pterm.LightGreen(targetDirectory))
+	errTable := pterm.DefaultTable.WithData(pterm.TableData{
+		{"Target directory", targetDirectory},
+		{"Recursively", fmt.Sprintf("%t", c.Bool("recursive"))},
+		{"Folder template", folder.spec},
+		{"Match", match},
+		{"On collision", collisionPolicy},
+		{"Filters", filter.String()},
+		{"Files", strconv.Itoa(len(targetDirectoryInfo.Files))},
+		{"JPEG / PNG / GIF", fmt.Sprintf("%d / %d / %d", targetDirectoryInfo.JpegCount, targetDirectoryInfo.PngCount, targetDirectoryInfo.GifCount)},
+		{"Planned moves", strconv.Itoa(plan.Changed())},
+		{"New folders", strconv.Itoa(plan.newDirectories())},
+		{"Failing moves", strconv.Itoa(len(plan.Failed()))},
+		{"Protected", plan.protectedDescription()},
+		{"Git", plan.gitDescription()},
+	}).Render()
+	if errTable != nil {
+		log.Error().Err(errTable).Msg("Failed to render table")
+		return errTable
+	}
+	if len(plan.Failed()) > 0 {
+		pterm.Error.Println("Some moves would fail, run with --dry-run to see all of them.")
+		return ErrPlanHasFailures
+	}
+	confirmed, errAsk := prompt.Confirm(c, "Are you sure you want to organize the files in "+pterm.LightGreen(targetDirectory)+"?", false)
+	if errAsk != nil {
+		log.Error().Err(errAsk).Msg("Failed to get ask for confirmation")
+		return errAsk
+	}
+	if !confirmed {
+		return nil
+	}
+
+	_, errApply := applyPlan(plan, "organize")
+	return errApply
+}
@@ -0,0 +1,267 @@
+package edit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/pterm/pterm"
+
+	"DevToolsCLI/file"
+)
+
+var (
+	ErrPlanHasFailures = errors.New("one or more planned renames would fail")
+	ErrEmptyName       = errors.New("new name is empty")
+	ErrInvalidName     = errors.New("new name is not a valid file name")
+)
+
+type Operation struct {
+	Source    string
+	Target    string
+	IsDir     bool
+	Err       error
+	Collision *CollisionError
+}
+
+func (o Operation) Unchanged() bool {
+	return o.Source == o.Target
+}
+
+// Plan is the full set of renames for one run. Operations are planned against
+// the original tree, unless Sequential is set, in which case they run exactly
+// in order with each path valid at the time its operation runs, as when
+// replaying a journal.
+type Plan struct {
+	Root       string
+	Operations []Operation
+	Sequential bool
+	Protected  []string
+
+	protectedPatterns []string
+	git               *gitRepository
+}
+
+type PlanOptions struct {
+	Recursive       bool
+	Filter          file.Filter
+	Protected       []string
+	Pipeline        Pipeline
+	CollisionPolicy string
+	// Folder moves files into folders under the root instead of renaming
+	// them, directories are walked but not planned.
+	Folder *folderRule
+}
+
+// buildPlan walks root and works out the new name of every entry without
+// touching the disk. Targets keep the original parent directory, the order in
+// which entries are renamed is worked out when the plan is applied.
+func buildPlan(root string, opts PlanOptions) (Plan, error) {
+	plan := Plan{Root: root, protectedPatterns: opts.Protected}
+	var dirs []Operation
+	errWalk := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		if isProtected(opts.Protected, root, path) {
+			plan.Protected = append(plan.Protected, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if !opts.Recursive {
+				return filepath.SkipDir
+			}
+			if opts.Folder == nil && opts.Filter.SelectsDir(root, path) {
+				dirs = append(dirs, Operation{Source: path, IsDir: true})
+			}
+			if !opts.Filter.AllowsDir(root, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !opts.Filter.AllowsPath(root, path) {
+			return nil
+		}
+		if opts.Filter.NeedsType() {
+			fileType, _ := file.GetFileTypeFromFilePath(path)
+			if !opts.Filter.AllowsType(fileType) {
+				return nil
+			}
+		}
+		plan.Operations = append(plan.Operations, Operation{Source: path})
+		return nil
+	})
+	if errWalk != nil {
+		return plan, errWalk
+	}
+	plan.Operations = append(plan.Operations, dirs...)
+	for i := range plan.Operations {
+		if opts.Folder != nil {
+			plan.Operations[i] = organizeOperation(root, plan.Operations[i].Source, i+1, opts)
+			continue
+		}
+		plan.Operations[i] = planOperation(plan.Operations[i].Source, plan.Operations[i].IsDir, i+1, opts.Pipeline)
+	}
+	errCollisions := plan.resolveCollisions(opts.CollisionPolicy)
+	return plan, errCollisions
+}
+
+func planOperation(path string, isDir bool, index int, pipeline Pipeline) Operation {
+	renamed := pipeline.Apply(filepath.Base(path), Target{Path: path, IsDir: isDir, Index: index})
+	op := Operation{
+		Source: path,
+		Target: filepath.Join(filepath.Dir(path), renamed),
+		IsDir:  isDir,
+	}
+	switch {
+	case renamed == "":
+		op.Err = ErrEmptyName
+	case renamed == "." || renamed == ".." || strings.ContainsRune(renamed, filepath.Separator):
+		op.Err = ErrInvalidName
+	}
+	return op
+}
+
+func (p Plan) Failed() []Operation {
+	var failed []Operation
+	for _, op := range p.Operations {
+		if op.Err != nil {
+			failed = append(failed, op)
+		}
+	}
+	return failed
+}
+
+func (p Plan) Changed() int {
+	changed := 0
+	for _, op := range p.Operations {
+		if op.Err == nil && !op.Unchanged() {
+			changed++
+		}
+	}
+	return changed
+}
+
+func (p Plan) protectedDescription() string {
+	if len(p.Protected) == 0 {
+		return "none"
+	}
+	rels := make([]string, 0, len(p.Protected))
+	for _, path := range p.Protected {
+		rels = append(rels, p.relative(path))
+	}
+	return strings.Join(rels, ", ")
+}
+
+// changes returns a plan with only the entries that are renamed or fail.
+func (p Plan) changes() Plan {
+	changed := Plan{Root: p.Root, Sequential: p.Sequential, Protected: p.Protected, protectedPatterns: p.protectedPatterns, git: p.git}
+	for _, op := range p.Operations {
+		if op.Err != nil || !op.Unchanged() {
+			changed.Operations = append(changed.Operations, op)
+		}
+	}
+	return changed
+}
+
+func (p Plan) relative(path string) string {
+	rel, err := filepath.Rel(p.Root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+func (p Plan) RenderTable() error {
+	data := pterm.TableData{{"Status", "Old name", "New name", "Note"}}
+	targets := p.finalTargets()
+	for i, op := range p.Operations {
+		status := "rename"
+		note := ""
+		switch {
+		case op.Err != nil:
+			status = pterm.Red("fail")
+			note = op.Err.Error()
+		case op.Collision != nil && op.Unchanged():
+			status = pterm.Yellow("skip")
+			note = op.Collision.Error()
+		case op.Collision != nil:
+			status = pterm.Yellow("rename")
+			note = op.Collision.Error()
+		case op.Unchanged():
+			status = pterm.Gray("same")
+		}
+		data = append(data, []string{status, p.relative(op.Source), p.relative(targets[i]), note})
+	}
+	return pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+type jsonOperation struct {
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Type      string `json:"type"`
+	Unchanged bool   `json:"unchanged"`
+	Collision string `json:"collision,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type jsonReference struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+func (p Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p.jsonOperations())
+}
+
+// WriteJSONWithReferences writes the planned renames along with the
+// references that --rewrite-refs would rewrite.
+func (p Plan) WriteJSONWithReferences(w io.Writer, references []Reference) error {
+	refs := make([]jsonReference, 0, len(references))
+	for _, reference := range references {
+		refs = append(refs, jsonReference{File: reference.File, Line: reference.Line, Old: reference.Old, New: reference.New})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Renames    []jsonOperation `json:"renames"`
+		References []jsonReference `json:"references"`
+	}{p.jsonOperations(), refs})
+}
+
+func (p Plan) jsonOperations() []jsonOperation {
+	ops := make([]jsonOperation, 0, len(p.Operations))
+	targets := p.finalTargets()
+	for i, op := range p.Operations {
+		jOp := jsonOperation{
+			Source:    op.Source,
+			Target:    targets[i],
+			Type:      "file",
+			Unchanged: op.Unchanged(),
+		}
+		if op.IsDir {
+			jOp.Type = "directory"
+		}
+		if op.Collision != nil {
+			jOp.Collision = op.Collision.Error()
+		}
+		if op.Err != nil {
+			jOp.Error = op.Err.Error()
+		}
+		ops = append(ops, jOp)
+	}
+	return ops
+}
@@ -0,0 +1,68 @@
+package edit
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"DevToolsCLI/file"
+)
+
+func TestBuildPlanFilters(t *testing.T) {
+	tree := []string{"A.txt", "Dir/B.txt", "Dir/Sub/C.txt", "Other/D.txt", ".Hidden/E.txt"}
+	tests := []struct {
+		name   string
+		filter file.Filter
+		want   []string
+	}{
+		{
+			name: "no filter",
+			want: []string{".Hidden", ".Hidden/E.txt", "A.txt", "Dir", "Dir/B.txt", "Dir/Sub", "Dir/Sub/C.txt", "Other", "Other/D.txt"},
+		},
+		{
+			name:   "max depth 1 renames top level files and directories",
+			filter: file.Filter{MaxDepth: 1},
+			want:   []string{".Hidden", "A.txt", "Dir", "Other"},
+		},
+		{
+			name:   "max depth 2",
+			filter: file.Filter{MaxDepth: 2},
+			want:   []string{".Hidden", ".Hidden/E.txt", "A.txt", "Dir", "Dir/B.txt", "Dir/Sub", "Other", "Other/D.txt"},
+		},
+		{
+			name:   "include selects matching directories only",
+			filter: file.Filter{Include: []string{"Dir/**"}},
+			want:   []string{"Dir", "Dir/B.txt", "Dir/Sub", "Dir/Sub/C.txt"},
+		},
+		{
+			name:   "exclude and skip hidden",
+			filter: file.Filter{Exclude: []string{"Other"}, SkipHidden: true},
+			want:   []string{"A.txt", "Dir", "Dir/B.txt", "Dir/Sub", "Dir/Sub/C.txt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeTree(t, root, tree)
+			plan, errPlan := buildPlan(root, PlanOptions{
+				Recursive:       true,
+				Filter:          tt.filter,
+				Pipeline:        Pipeline{},
+				CollisionPolicy: CollisionAbort,
+			})
+			if errPlan != nil {
+				t.Fatal(errPlan)
+			}
+			var got []string
+			for _, op := range plan.Operations {
+				rel, _ := filepath.Rel(root, op.Source)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planned = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"DevToolsCLI/file"
+)
+
+var (
+	ErrProtected       = errors.New("path is protected")
+	ErrProtectedTarget = errors.New("refusing to rename in a protected directory, use --force to do it anyway")
+)
+
+// defaultProtected are never renamed or walked into. Renaming anything inside
+// version control metadata corrupts the repository and the rest belong to the
+// package manager or the operating system.
+var defaultProtected = []string{
+	".git", ".svn", ".hg", ".bzr", "_darcs", "CVS",
+	"node_modules",
+	"lost+found", ".Trash", ".Trashes", "$RECYCLE.BIN", "System Volume Information",
+}
+
+var repositoryMarkers = []string{".git", ".svn", ".hg", ".bzr", "_darcs"}
+
+func protectedPatterns(extra []string, useDefaults bool) []string {
+	var patterns []string
+	if useDefaults {
+		patterns = append(patterns, defaultProtected...)
+	}
+	return append(patterns, extra...)
+}
+
+// isProtected reports whether path, or any directory between root and path,
+// matches one of the protected patterns.
+func isProtected(patterns []string, root, path string) bool {
+	rel, errRel := filepath.Rel(root, path)
+	if errRel != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pattern := range patterns {
+			if file.MatchGlob(pattern, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTarget refuses the filesystem root, the home directory and the root of
+// a repository, where a recursive rename does the most damage, as well as
+// targets inside a protected directory, which the walk never sees.
+func checkTarget(target string, patterns []string) error {
+	absolute, errAbs := filepath.Abs(target)
+	if errAbs != nil {
+		return errAbs
+	}
+	if resolved, errEval := filepath.EvalSymlinks(absolute); errEval == nil {
+		absolute = resolved
+	}
+	if absolute == filepath.VolumeName(absolute)+string(filepath.Separator) {
+		return fmt.Errorf("%w: %s is the filesystem root", ErrProtectedTarget, absolute)
+	}
+	if home, errHome := os.UserHomeDir(); errHome == nil {
+		if resolved, errEval := filepath.EvalSymlinks(home); errEval == nil {
+			home = resolved
+		}
+		if absolute == home {
+			return fmt.Errorf("%w: %s is the home directory", ErrProtectedTarget, absolute)
+		}
+	}
+	for _, marker := range repositoryMarkers {
+		if _, errStat := os.Lstat(filepath.Join(absolute, marker)); errStat == nil {
+			return fmt.Errorf("%w: %s is the root of a repository", ErrProtectedTarget, absolute)
+		}
+	}
+	if dir, protected := protectedAncestor(patterns, absolute); protected {
+		return fmt.Errorf("%w: %s is protected", ErrProtectedTarget, dir)
+	}
+	return nil
+}
+
+// protectedAncestor returns the outermost directory of the absolute path,
+// including the path itself, that matches one of the protected patterns.
+// Patterns with a slash may match any run of directories ending in it.
+func protectedAncestor(patterns []string, absolute string) (string, bool) {
+	volume := filepath.VolumeName(absolute)
+	parts := strings.Split(filepath.ToSlash(strings.TrimPrefix(absolute, volume)), "/")
+	for i := range parts {
+		if parts[i] == "" {
+			continue
+		}
+		for start := 0; start <= i; start++ {
+			rel := strings.Trim(strings.Join(parts[start:i+1], "/"), "/")
+			for _, pattern := range patterns {
+				if file.MatchGlob(pattern, rel) {
+					return volume + filepath.FromSlash(strings.Join(parts[:i+1], "/")), true
+				}
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,299 @@
+package edit
+
+import (
+	"bytes"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+
+	"DevToolsCLI/file"
+)
+
+// Reference is one occurrence of an old path in a source file.
+type Reference struct {
+	File string
+	Line int
+	Old  string
+	New  string
+	// offset is where New starts in the rewritten file.
+	offset int
+}
+
+// renameMap translates paths between the tree before and after a plan ran.
+// Renames are sorted deepest first, so the first one matching a path is the
+// most specific.
+type renameMap struct {
+	renames []Operation
+}
+
+func newRenameMap(plan Plan) renameMap {
+	var m renameMap
+	targets := plan.finalTargets()
+	for i, op := range plan.Operations {
+		if op.Err != nil || op.Unchanged() {
+			continue
+		}
+		source, errSource := filepath.Abs(op.Source)
+		target, errTarget := filepath.Abs(targets[i])
+		if errSource != nil || errTarget != nil {
+			continue
+		}
+		m.renames = append(m.renames, Operation{Source: source, Target: target, IsDir: op.IsDir})
+	}
+	sort.SliceStable(m.renames, func(a, b int) bool {
+		return depthOf(m.renames[a].Source) > depthOf(m.renames[b].Source)
+	})
+	return m
+}
+
+func (m renameMap) forward(path string) string {
+	for _, r := range m.renames {
+		if path == r.Source {
+			return r.Target
+		}
+		if r.IsDir && strings.HasPrefix(path, r.Source+string(filepath.Separator)) {
+			return r.Target + path[len(r.Source):]
+		}
+	}
+	return path
+}
+
+func (m renameMap) reverse(path string) string {
+	for _, r := range m.renames {
+		if path == r.Target {
+			return r.Source
+		}
+		if r.IsDir && strings.HasPrefix(path, r.Target+string(filepath.Separator)) {
+			return r.Source + path[len(r.Target):]
+		}
+	}
+	return path
+}
+
+// referenceFiles walks root and returns the files matching one of the globs,
+// skipping protected directories.
+func referenceFiles(root string, globs, protected []string) ([]string, error) {
+	var files []string
+	errWalk := filepath.WalkDir(root, func(path string, d fs.DirEntry, errWalk error) error {
+		if errWalk != nil {
+			return errWalk
+		}
+		if path == root {
+			return nil
+		}
+		if isProtected(protected, root, path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, errRel := filepath.Rel(root, path)
+		if errRel != nil {
+			return errRel
+		}
+		for _, glob := range globs {
+			if file.MatchGlob(glob, filepath.ToSlash(rel)) {
+				files = append(files, path)
+				break
+			}
+		}
+		return nil
+	})
+	return files, errWalk
+}
+
+type replacement struct {
+	old   string
+	new   string
+	isDir bool
+}
+
+// replacements lists the ways source, which lived at oldPath before the
+// renames and at newPath after, can refer to the renamed entries: relative to
+// its own directory, relative to root with a leading slash, and both of those
+// URL encoded.
+func (m renameMap) replacements(root, oldPath, newPath string) []replacement {
+	var result []replacement
+	add := func(oldRef, newRef string, isDir bool) {
+		if oldRef != newRef && oldRef != "" {
+			result = append(result, replacement{old: oldRef, new: newRef, isDir: isDir})
+		}
+		escapedOld, escapedNew := escapePath(oldRef), escapePath(newRef)
+		if escapedOld != oldRef && escapedOld != escapedNew {
+			result = append(result, replacement{old: escapedOld, new: escapedNew, isDir: isDir})
+		}
+	}
+	for _, r := range m.renames {
+		oldRef, errOld := filepath.Rel(filepath.Dir(oldPath), r.Source)
+		newRef, errNew := filepath.Rel(filepath.Dir(newPath), r.Target)
+		if errOld == nil && errNew == nil {
+			add(filepath.ToSlash(oldRef), filepath.ToSlash(newRef), r.IsDir)
+		}
+		oldRooted, errOld := filepath.Rel(root, r.Source)
+		newRooted, errNew := filepath.Rel(root, r.Target)
+		if errOld == nil && errNew == nil && !strings.HasPrefix(oldRooted, "..") && !strings.HasPrefix(newRooted, "..") {
+			add("/"+filepath.ToSlash(oldRooted), "/"+filepath.ToSlash(newRooted), r.IsDir)
+		}
+	}
+	sort.SliceStable(result, func(a, b int) bool {
+		return len(result[a].old) > len(result[b].old)
+	})
+	return result
+}
+
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func isPathByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte("._-~%", b) >= 0
+}
+
+// referenceBoundary reports whether the match at content[start:end] is a
+// whole path rather than part of a longer one. References may start with ./
+// and references to directories may continue with the path inside them.
+func referenceBoundary(content []byte, start, end int, isDir bool) bool {
+	before := start
+	if before >= 2 && content[before-2] == '.' && content[before-1] == '/' {
+		before -= 2
+	}
+	if before > 0 && (isPathByte(content[before-1]) || content[before-1] == '/' || content[before-1] == '\\') {
+		return false
+	}
+	if end < len(content) {
+		next := content[end]
+		if isPathByte(next) || next == '/' && !isDir {
+			return false
+		}
+	}
+	return true
+}
+
+type referenceMatch struct {
+	start int
+	end   int
+	with  replacement
+}
+
+// rewriteContent replaces every reference in content, longer references win
+// over shorter ones that overlap them.
+func rewriteContent(content []byte, replacements []replacement) ([]byte, []referenceMatch) {
+	var matches []referenceMatch
+	taken := make([]bool, len(content))
+	for _, r := range replacements {
+		old := []byte(r.old)
+		for offset := 0; offset < len(content); {
+			index := bytes.Index(content[offset:], old)
+			if index < 0 {
+				break
+			}
+			start := offset + index
+			end := start + len(old)
+			offset = start + 1
+			if taken[start] || taken[end-1] || !referenceBoundary(content, start, end, r.isDir) {
+				continue
+			}
+			for i := start; i < end; i++ {
+				taken[i] = true
+			}
+			matches = append(matches, referenceMatch{start: start, end: end, with: r})
+		}
+	}
+	if len(matches) == 0 {
+		return content, nil
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		return matches[a].start < matches[b].start
+	})
+	var out bytes.Buffer
+	last := 0
+	for _, match := range matches {
+		out.Write(content[last:match.start])
+		out.WriteString(match.with.new)
+		last = match.end
+	}
+	out.Write(content[last:])
+	return out.Bytes(), matches
+}
+
+// rewriteReferences finds the references to renamed entries in files. With
+// write set the files are expected to be in their renamed place and are
+// rewritten, otherwise they are only read and the references that would be
+// rewritten are returned.
+func rewriteReferences(root string, files []string, m renameMap, write bool) ([]Reference, error) {
+	var references []Reference
+	for _, path := range files {
+		path, errAbs := filepath.Abs(path)
+		if errAbs != nil {
+			return references, errAbs
+		}
+		oldPath, newPath := path, m.forward(path)
+		if write {
+			oldPath, newPath = m.reverse(path), path
+		}
+		content, errRead := os.ReadFile(path)
+		if errRead != nil {
+			return references, errRead
+		}
+		if bytes.IndexByte(content, 0) >= 0 {
+			continue
+		}
+		rewritten, matches := rewriteContent(content, m.replacements(root, oldPath, newPath))
+		var fileReferences []Reference
+		shift := 0
+		for _, match := range matches {
+			fileReferences = append(fileReferences, Reference{
+				File:   path,
+				Line:   bytes.Count(content[:match.start], []byte("\n")) + 1,
+				Old:    match.with.old,
+				New:    match.with.new,
+				offset: match.start + shift,
+			})
+			shift += len(match.with.new) - len(match.with.old)
+		}
+		if write && len(matches) > 0 {
+			info, errStat := os.Stat(path)
+			if errStat != nil {
+				return references, errStat
+			}
+			if errWrite := os.WriteFile(path, rewritten, info.Mode().Perm()); errWrite != nil {
+				return references, errWrite
+			}
+		}
+		references = append(references, fileReferences...)
+	}
+	return references, nil
+}
+
+func renderReferences(root string, references []Reference) error {
+	data := pterm.TableData{{"File", "Line", "Old reference", "New reference"}}
+	for _, reference := range references {
+		rel, errRel := filepath.Rel(root, reference.File)
+		if errRel != nil {
+			rel = reference.File
+		}
+		data = append(data, []string{rel, strconv.Itoa(reference.Line), reference.Old, reference.New})
+	}
+	return pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+func referencedFiles(references []Reference) int {
+	seen := map[string]bool{}
+	for _, reference := range references {
+		seen[reference.File] = true
+	}
+	return len(seen)
+}
@@ -0,0 +1,181 @@
+package edit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReferenceBoundary(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		old     string
+		isDir   bool
+		want    bool
+	}{
+		{name: "whole path", content: `src="a.png"`, old: "a.png", want: true},
+		{name: "start and end of content", content: "a.png", old: "a.png", want: true},
+		{name: "longer name before", content: "aa.png", old: "a.png", want: false},
+		{name: "longer name after", content: "a.png.bak", old: "a.png", want: false},
+		{name: "dot slash prefix", content: "(./a.png)", old: "a.png", want: true},
+		{name: "parent directory", content: "(../a.png)", old: "a.png", want: false},
+		{name: "other directory", content: "(img/a.png)", old: "a.png", want: false},
+		{name: "backslash", content: `img\a.png`, old: "a.png", want: false},
+		{name: "file followed by slash", content: "a.png/x", old: "a.png", want: false},
+		{name: "directory followed by slash", content: "Sub/keep.png", old: "Sub", isDir: true, want: true},
+		{name: "longer directory", content: "Subs/keep.png", old: "Sub", isDir: true, want: false},
+		{name: "escaped space after", content: "a.png%20b", old: "a.png", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := strings.Index(tt.content, tt.old)
+			if got := referenceBoundary([]byte(tt.content), start, start+len(tt.old), tt.isDir); got != tt.want {
+				t.Errorf("referenceBoundary(%q, %q) = %t, want %t", tt.content, tt.old, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteContent(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		replacements []replacement
+		want         string
+		matches      int
+	}{
+		{
+			name:         "prefix overlaps",
+			content:      "a.png aa.png a.png.bak ba.png a.png",
+			replacements: []replacement{{old: "a.png", new: "b.png"}},
+			want:         "b.png aa.png a.png.bak ba.png b.png",
+			matches:      2,
+		},
+		{
+			name:    "longer reference wins",
+			content: "img/a.png a.png",
+			replacements: []replacement{
+				{old: "img/a.png", new: "img/c.png"},
+				{old: "a.png", new: "b.png"},
+			},
+			want:    "img/c.png b.png",
+			matches: 2,
+		},
+		{
+			name:         "dot slash prefix",
+			content:      "[x](./a.png) [y](../a.png)",
+			replacements: []replacement{{old: "a.png", new: "b.png"}},
+			want:         "[x](./b.png) [y](../a.png)",
+			matches:      1,
+		},
+		{
+			name:    "url escaped",
+			content: `<img src="Big%20Logo.png"> [x](Big Logo.png) Big%20Logo.pngs`,
+			replacements: []replacement{
+				{old: "Big%20Logo.png", new: "big-logo.png"},
+				{old: "Big Logo.png", new: "big-logo.png"},
+			},
+			want:    `<img src="big-logo.png"> [x](big-logo.png) Big%20Logo.pngs`,
+			matches: 2,
+		},
+		{
+			name:         "directory prefix",
+			content:      "Sub Dir/keep.png Sub Dir Sub Dirs/keep.png",
+			replacements: []replacement{{old: "Sub Dir", new: "sub-dir", isDir: true}},
+			want:         "sub-dir/keep.png sub-dir Sub Dirs/keep.png",
+			matches:      2,
+		},
+		{
+			name:         "file isn't a directory",
+			content:      "a.png/keep.png",
+			replacements: []replacement{{old: "a.png", new: "b.png"}},
+			want:         "a.png/keep.png",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matches := rewriteContent([]byte(tt.content), tt.replacements)
+			if string(got) != tt.want {
+				t.Errorf("rewriteContent() = %q, want %q", got, tt.want)
+			}
+			if len(matches) != tt.matches {
+				t.Errorf("rewriteContent() made %d replacements, want %d", len(matches), tt.matches)
+			}
+		})
+	}
+}
+
+func TestRenameMapReplacements(t *testing.T) {
+	root := filepath.FromSlash("/site")
+	m := renameMap{renames: []Operation{{
+		Source: filepath.FromSlash("/site/assets/Big Logo.png"),
+		Target: filepath.FromSlash("/site/assets/big-logo.png"),
+	}}}
+	source := filepath.FromSlash("/site/docs/index.md")
+	got := map[string]string{}
+	for _, r := range m.replacements(root, source, source) {
+		got[r.old] = r.new
+	}
+	want := map[string]string{
+		"../assets/Big Logo.png":   "../assets/big-logo.png",
+		"../assets/Big%20Logo.png": "../assets/big-logo.png",
+		"/assets/Big Logo.png":     "/assets/big-logo.png",
+		"/assets/Big%20Logo.png":   "/assets/big-logo.png",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replacements() = %v, want %v", got, want)
+	}
+}
+
+func TestJournalUndoRewrites(t *testing.T) {
+	root := t.TempDir()
+	original := "![logo](assets/Big%20Logo.png)\n[docs](./Sub Dir/index.md) and Sub Dir/index.md\n"
+	writeTree(t, root, []string{"assets/big-logo.png", "sub-dir/index.md"})
+	readme := filepath.Join(root, "README.md")
+	if errWrite := os.WriteFile(readme, []byte(original), 0o644); errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	m := renameMap{renames: []Operation{
+		{Source: filepath.Join(root, "assets", "Big Logo.png"), Target: filepath.Join(root, "assets", "big-logo.png")},
+		{Source: filepath.Join(root, "Sub Dir"), Target: filepath.Join(root, "sub-dir"), IsDir: true},
+	}}
+	references, errRewrite := rewriteReferences(root, []string{readme}, m, true)
+	if errRewrite != nil {
+		t.Fatal(errRewrite)
+	}
+	if len(references) != 3 {
+		t.Fatalf("rewriteReferences() rewrote %d references, want 3", len(references))
+	}
+	rewritten, errRead := os.ReadFile(readme)
+	if errRead != nil {
+		t.Fatal(errRead)
+	}
+	wantRewritten := "![logo](assets/big-logo.png)\n[docs](./sub-dir/index.md) and sub-dir/index.md\n"
+	if string(rewritten) != wantRewritten {
+		t.Fatalf("rewritten file = %q, want %q", rewritten, wantRewritten)
+	}
+
+	var j Journal
+	j.addRewrites(references)
+	restored, errUndo := j.undoRewrites()
+	if errUndo != nil {
+		t.Fatal(errUndo)
+	}
+	if errRestore := restoreFiles(restored); errRestore != nil {
+		t.Fatal(errRestore)
+	}
+	if got := readTree(t, root)["README.md"]; got != original {
+		t.Errorf("restored file = %q, want %q", got, original)
+	}
+
+	if errWrite := os.WriteFile(readme, []byte("edited\n"), 0o644); errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	if _, errUndo := j.undoRewrites(); !errors.Is(errUndo, ErrJournalMismatch) {
+		t.Errorf("undoRewrites() of an edited file error = %v, want %v", errUndo, ErrJournalMismatch)
+	}
+}
@@ -1,28 +1,29 @@
 package edit
 
 import (
+	"errors"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 
 	"DevToolsCLI/file"
+	"DevToolsCLI/prompt"
 )
 
 var subCommandRename = &cli.Command{
 	Name:        "rename",
-	Description: "Rename files in a directory. This currently only supports renaming files to be URL safe.",
-	Flags: []cli.Flag{
+	Description: "Rename files in a directory using an ordered list of rules. Defaults to the url-safe preset.",
+	Flags: append(append([]cli.Flag{
 		&cli.StringFlag{
 			Name:        "target",
-			Required:    true,
+			Required:    false,
 			DefaultText: "the directory you want to rename the files in",
 		},
 		&cli.BoolFlag{
@@ -31,25 +32,225 @@ var subCommandRename = &cli.Command{
 			Usage:    "recursively rename files in subdirectories",
 			Value:    false,
 		},
-	},
+		&cli.StringSliceFlag{
+			Name:     "rule",
+			Required: false,
+			Usage:    "rename rule to apply, can be repeated and runs in order (e.g. lower, snake, prefix:img_, regex:/ +/_/, preset:url-safe)",
+		},
+		&cli.StringFlag{
+			Name:     "rules-file",
+			Required: false,
+			Usage:    "file with one rename rule per line, applied before any --rule flags",
+		},
+		&cli.StringFlag{
+			Name:     "template",
+			Required: false,
+			Usage:    "build new names from a template such as {mtime:2006-01-02}_{n:04}{ext}, runs before any rules",
+		},
+		&cli.BoolFlag{
+			Name:     "refuse-dirty",
+			Required: false,
+			Usage:    "refuse to rename tracked files with uncommitted changes",
+			Value:    false,
+		},
+		&cli.StringSliceFlag{
+			Name:     "rewrite-refs",
+			Required: false,
+			Usage:    "after renaming, rewrite references to the old paths in files matching this glob, can be repeated (e.g. *.html, **/*.md)",
+		},
+		&cli.StringFlag{
+			Name:     "refs-root",
+			Required: false,
+			Usage:    "directory to search for --rewrite-refs files and resolve /absolute references against, defaults to the target directory",
+		},
+		&cli.BoolFlag{
+			Name:     "interactive-editor",
+			Required: false,
+			Usage:    "edit the new names by hand in $EDITOR instead of using rules",
+			Value:    false,
+		},
+		&cli.StringFlag{
+			Name:     "export-plan",
+			Required: false,
+			Usage:    "write the planned renames to a CSV file instead of renaming anything",
+		},
+		&cli.StringFlag{
+			Name:     "apply-plan",
+			Required: false,
+			Usage:    "rename files using the old and new columns of a CSV file, paths are relative to --target",
+		},
+		&cli.BoolFlag{
+			Name:     "undo",
+			Required: false,
+			Usage:    "reverse the most recent rename, or the journal passed as an argument",
+			Value:    false,
+		},
+	}, planFlags("rename", "show what every file would be renamed to without renaming anything")...), file.FilterFlags()...),
 	Action: EscapeRenameFiles,
 }
 
-func rename(path string) error {
-	base := filepath.Base(path)
-	dir := filepath.Dir(path)
-	baseEscaped := strings.ReplaceAll(base, " ", "_")
-	baseEscaped = strings.ToLower(baseEscaped)
-	repl := regexp.MustCompile(`[^a-zA-Z0-9-._~]+`)
-	baseEscaped = repl.ReplaceAllString(baseEscaped, "")
-	errRename := os.Rename(path, filepath.Join(dir, baseEscaped))
-	return errRename
+var (
+	ErrTargetRequired      = errors.New("required flag \"target\" not set")
+	ErrEditorNeedsTerminal = errors.New("--interactive-editor needs an interactive terminal")
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+func pipelineFromContext(c *cli.Context) (Pipeline, error) {
+	var specs []string
+	if c.IsSet("rules-file") {
+		fileSpecs, errLoad := LoadRulesFile(c.String("rules-file"))
+		if errLoad != nil {
+			return nil, errLoad
+		}
+		specs = append(specs, fileSpecs...)
+	}
+	specs = append(specs, c.StringSlice("rule")...)
+	if c.IsSet("template") {
+		specs = append([]string{"template:" + c.String("template")}, specs...)
+	}
+	if len(specs) == 0 {
+		return Preset(presetURLSafe)
+	}
+	return ParseRules(specs)
+}
+
+// planFromContext builds the plan from a mapping file, from names edited in
+// the user's editor or from the rename rules, and describes where the new
+// names came from for the summary.
+func planFromContext(c *cli.Context, settings planSettings) (Plan, string, error) {
+	targetDirectory, protected, collisionPolicy := settings.Target, settings.Protected, settings.CollisionPolicy
+	if c.IsSet("apply-plan") {
+		planFile := c.String("apply-plan")
+		plan, errRead := readPlanCSV(targetDirectory, planFile, protected)
+		if errRead != nil {
+			return plan, "", errRead
+		}
+		return plan, "mapping from " + planFile, plan.resolveCollisions(collisionPolicy)
+	}
+	useEditor := c.Bool("interactive-editor")
+	if useEditor && (c.Bool("non-interactive") || !prompt.Terminal()) {
+		return Plan{}, "", ErrEditorNeedsTerminal
+	}
+	pipeline := Pipeline{}
+	if !useEditor {
+		var errPipeline error
+		pipeline, errPipeline = pipelineFromContext(c)
+		if errPipeline != nil {
+			return Plan{}, "", errPipeline
+		}
+	}
+	plan, errPlan := buildPlan(targetDirectory, PlanOptions{
+		Recursive:       c.IsSet("recursive"),
+		Filter:          settings.Filter,
+		Protected:       protected,
+		Pipeline:        pipeline,
+		CollisionPolicy: collisionPolicy,
+	})
+	if errPlan != nil || !useEditor {
+		return plan, pipeline.String(), errPlan
+	}
+	plan, errPlan = editPlan(plan)
+	if errPlan != nil {
+		return plan, "", errPlan
+	}
+	return plan, "edited in " + editor()[0], plan.resolveCollisions(collisionPolicy)
+}
+
+func referencesDescription(c *cli.Context) string {
+	globs := c.StringSlice("rewrite-refs")
+	if len(globs) == 0 {
+		return "none"
+	}
+	root := c.String("refs-root")
+	if root == "" {
+		return strings.Join(globs, " ")
+	}
+	return strings.Join(globs, " ") + " under " + root
 }
 
 func EscapeRenameFiles(c *cli.Context) error {
-	targetDirectory := c.String("target")
+	if c.Bool("undo") {
+		return UndoRename(c)
+	}
+	settings, errSettings := planSettingsFromContext(c)
+	if errSettings != nil {
+		return errSettings
+	}
+	targetDirectory, filter, collisionPolicy := settings.Target, settings.Filter, settings.CollisionPolicy
 	recursively := c.IsSet("recursive")
-	targetDirectoryInfo, errDir := file.GetDirectoryInfo(targetDirectory)
+	dryRun, format := settings.DryRun, settings.Format
+	plan, namesFrom, errPlan := planFromContext(c, settings)
+	if errPlan != nil {
+		log.Error().Err(errPlan).Msg("Failed to plan renames")
+		return errPlan
+	}
+	repository := findGitRepository(targetDirectory)
+	settings.useGit(&plan, repository)
+	if c.Bool("refuse-dirty") {
+		if errDirty := plan.refuseDirty(repository); errDirty != nil {
+			log.Error().Err(errDirty).Msg("Failed to check for uncommitted changes")
+			return errDirty
+		}
+	}
+	if c.Bool("interactive-editor") {
+		if plan.Changed() == 0 && len(plan.Failed()) == 0 {
+			pterm.Info.Println("No names were changed, nothing to rename.")
+			return nil
+		}
+		if !dryRun && !c.IsSet("export-plan") {
+			pterm.DefaultSection.Println("Edited renames")
+			errTable := plan.changes().RenderTable()
+			if errTable != nil {
+				log.Error().Err(errTable).Msg("Failed to render table")
+				return errTable
+			}
+		}
+	}
+
+	if c.IsSet("export-plan") {
+		exportPath := c.String("export-plan")
+		errExport := writePlanCSV(plan, exportPath)
+		if errExport != nil {
+			log.Error().Err(errExport).Msg("Failed to export plan")
+			return errExport
+		}
+		pterm.Success.Printfln("Exported %d planned renames to %s.", plan.Changed(), pterm.LightGreen(exportPath))
+		if len(plan.Failed()) > 0 {
+			return ErrPlanHasFailures
+		}
+		return nil
+	}
+
+	if dryRun && format == formatJSON && len(c.StringSlice("rewrite-refs")) > 0 {
+		_, _, references, errRefs := findReferences(c, plan, false)
+		if errRefs != nil {
+			return errRefs
+		}
+		errJSON := plan.WriteJSONWithReferences(os.Stdout, references)
+		if errJSON != nil {
+			log.Error().Err(errJSON).Msg("Failed to write plan")
+			return errJSON
+		}
+		if len(plan.Failed()) > 0 {
+			return ErrPlanHasFailures
+		}
+		return nil
+	}
+	if dryRun {
+		errShow := showPlan(plan, format)
+		if len(c.StringSlice("rewrite-refs")) > 0 {
+			if _, errRefs := updateReferences(c, plan, false); errRefs != nil {
+				return errRefs
+			}
+		}
+		return errShow
+	}
+
+	targetDirectoryInfo, errDir := file.GetDirectoryInfo(targetDirectory, filter)
 	if errDir != nil {
 		log.Error().Err(errDir).Msg("Failed to get directory info")
 		return errDir
@@ -58,17 +259,27 @@ func EscapeRenameFiles(c *cli.Context) error {
 	errTable := pterm.DefaultTable.WithData(pterm.TableData{
 		{"Target directory", targetDirectory},
 		{"Recursively", fmt.Sprintf("%t", recursively)},
+		{"New names from", namesFrom},
+		{"On collision", collisionPolicy},
+		{"Filters", filter.String()},
 		{"Files", strconv.Itoa(len(targetDirectoryInfo.Files))},
 		{"Directories", strconv.FormatInt(targetDirectoryInfo.NumberOfDirectories, 10)},
+		{"Planned renames", strconv.Itoa(plan.Changed())},
+		{"Failing renames", strconv.Itoa(len(plan.Failed()))},
+		{"Protected", plan.protectedDescription()},
+		{"Git", plan.gitDescription()},
+		{"Rewrite references in", referencesDescription(c)},
 	}).Render()
 
 	if errTable != nil {
 		log.Error().Err(errTable).Msg("Failed to render table")
 		return errTable
 	}
-	confirmed, errAsk := pterm.DefaultInteractiveConfirm.
-		WithDefaultValue(false).
-		Show("Are you sure you want to rename all files in " + pterm.LightGreen(targetDirectory) + "?")
+	if len(plan.Failed()) > 0 {
+		pterm.Error.Println("Some renames would fail, run with --dry-run to see all of them.")
+		return ErrPlanHasFailures
+	}
+	confirmed, errAsk := prompt.Confirm(c, "Are you sure you want to rename all files in "+pterm.LightGreen(targetDirectory)+"?", false)
 	if errAsk != nil {
 		log.Error().Err(errAsk).Msg("Failed to get ask for confirmation")
 		return errAsk
@@ -77,42 +288,216 @@ func EscapeRenameFiles(c *cli.Context) error {
 		return nil
 	}
 
-	total := len(targetDirectoryInfo.Files) + int(targetDirectoryInfo.NumberOfDirectories)
+	journal, errApply := applyPlan(plan, "rename")
+	if errApply != nil || len(c.StringSlice("rewrite-refs")) == 0 {
+		return errApply
+	}
+	references, errRefs := updateReferences(c, plan, true)
+	if len(references) > 0 {
+		// The references are rewritten after the journal was first saved.
+		journal.addRewrites(references)
+		if _, errSave := journal.Save(); errSave != nil {
+			log.Error().Err(errSave).Msg("Failed to save rename journal")
+			return errSave
+		}
+	}
+	return errRefs
+}
+
+// updateReferences scans the files selected by --rewrite-refs for references
+// to entries of the plan and rewrites them when write is set, otherwise it
+// only lists them. The returned references were rewritten, even when an error
+// stopped the rewrite halfway.
+func updateReferences(c *cli.Context, plan Plan, write bool) ([]Reference, error) {
+	root, files, references, errRefs := findReferences(c, plan, write)
+	if errRefs != nil {
+		return references, errRefs
+	}
+	if len(references) == 0 {
+		pterm.Info.Printfln("No references to renamed files found in %d files.", len(files))
+		return nil, nil
+	}
+	if write {
+		pterm.DefaultSection.Println("Rewritten references in " + pterm.LightGreen(root))
+	} else {
+		pterm.DefaultSection.Println("References that would be rewritten in " + pterm.LightGreen(root))
+	}
+	errTable := renderReferences(root, references)
+	if errTable != nil {
+		log.Error().Err(errTable).Msg("Failed to render table")
+		return references, errTable
+	}
+	if write {
+		pterm.Success.Printfln("Rewrote %d references in %d files.", len(references), referencedFiles(references))
+	} else {
+		pterm.Info.Printfln("%d references in %d files would be rewritten.", len(references), referencedFiles(references))
+	}
+	return references, nil
+}
+
+// findReferences returns the root and the files selected by --rewrite-refs and
+// the references to entries of the plan in them, rewriting them when write is
+// set.
+func findReferences(c *cli.Context, plan Plan, write bool) (string, []string, []Reference, error) {
+	root := c.String("refs-root")
+	if root == "" {
+		root = plan.Root
+	}
+	root, errAbs := filepath.Abs(root)
+	if errAbs != nil {
+		log.Error().Err(errAbs).Msg("Failed to get absolute path")
+		return root, nil, nil, errAbs
+	}
+	protected := protectedPatterns(c.StringSlice("protect"), !c.Bool("no-default-protect"))
+	files, errFiles := referenceFiles(root, c.StringSlice("rewrite-refs"), protected)
+	if errFiles != nil {
+		log.Error().Err(errFiles).Msg("Failed to find files to rewrite references in")
+		return root, nil, nil, errFiles
+	}
+	references, errRewrite := rewriteReferences(root, files, newRenameMap(plan), write)
+	if errRewrite != nil {
+		log.Error().Err(errRewrite).Msg("Failed to rewrite references")
+		return root, files, references, errRewrite
+	}
+	return root, files, references, nil
+}
 
-	progressBar, errProgress := pterm.DefaultProgressbar.WithTotal(total).Start()
+// applyPlan runs the plan and records every rename that completed in a journal
+// so it can be reversed with --undo, even if the run stopped halfway. The
+// journal is empty when nothing was renamed.
+func applyPlan(plan Plan, command string) (Journal, error) {
+	var journal Journal
+	progressBar, errProgress := pterm.DefaultProgressbar.WithTotal(len(plan.Operations)).Start()
 	if errProgress != nil {
 		log.Error().Err(errProgress).Msg("Failed to start progress bar")
-		return errProgress
+		return journal, errProgress
 	}
 
-	var dirs []string
-	errWalk := filepath.WalkDir(targetDirectory, func(path string, d fs.DirEntry, err error) error {
-		if path == targetDirectory {
-			progressBar.Increment()
-			return nil
+	completed, created, errApply := plan.apply(func() {
+		progressBar.Increment()
+	})
+	if len(completed) > 0 {
+		var errJournal error
+		journal, errJournal = newJournal(command, plan.Root, completed, created)
+		if errJournal != nil {
+			log.Error().Err(errJournal).Msg("Failed to create rename journal")
+			return journal, errJournal
 		}
-		if d.IsDir() {
-			if !recursively {
-				return filepath.SkipDir
-			}
-			dirs = append(dirs, path)
-			return nil
+		journalPath, errSave := journal.Save()
+		if errSave != nil {
+			log.Error().Err(errSave).Msg("Failed to save rename journal")
+			return journal, errSave
 		}
-		errRename := rename(path)
+		pterm.Info.Println("Rename journal written to " + pterm.LightGreen(journalPath))
+	}
+	if errApply != nil {
+		log.Error().Err(errApply).Msg("Failed to rename files")
+		return journal, errApply
+	}
+	return journal, nil
+}
+
+func UndoRename(c *cli.Context) error {
+	if errFormat := validFormat(c.String("format")); errFormat != nil {
+		return errFormat
+	}
+	journalPath, errFind := findJournal(c.Args().First())
+	if errFind != nil {
+		log.Error().Err(errFind).Msg("Failed to find rename journal")
+		return errFind
+	}
+	journal, errLoad := loadJournal(journalPath)
+	if errLoad != nil {
+		log.Error().Err(errLoad).Msg("Failed to load rename journal")
+		return errLoad
+	}
+	plan, errPlan := journal.undoPlan()
+	if errPlan != nil {
+		log.Error().Err(errPlan).Msg("Failed to plan undo")
+		return errPlan
+	}
+	restored, errRewrites := journal.undoRewrites()
+	if errRewrites != nil {
+		log.Error().Err(errRewrites).Msg("Failed to plan undo")
+		return errRewrites
+	}
+	if !c.Bool("no-git") {
+		plan.git = findGitRepository(journal.Root)
+	}
+	if c.Bool("dry-run") {
+		return showPlan(plan, c.String("format"))
+	}
+
+	pterm.DefaultSection.Println("Undoing renames from " + pterm.LightGreen(journalPath))
+	errTable := pterm.DefaultTable.WithData(pterm.TableData{
+		{"Journal", journalPath},
+		{"Created", journal.Created.Format(time.RFC1123)},
+		{"Root", journal.Root},
+		{"Renames to undo", strconv.Itoa(len(plan.Operations))},
+		{"Files with references to restore", strconv.Itoa(len(restored))},
+		{"Git", plan.gitDescription()},
+	}).Render()
+	if errTable != nil {
+		log.Error().Err(errTable).Msg("Failed to render table")
+		return errTable
+	}
+	confirmed, errAsk := prompt.Confirm(c, "Are you sure you want to undo these renames?", false)
+	if errAsk != nil {
+		log.Error().Err(errAsk).Msg("Failed to get ask for confirmation")
+		return errAsk
+	}
+	if !confirmed {
+		return nil
+	}
+
+	// The rewritten files are restored where the renames left them.
+	if errRestore := restoreFiles(restored); errRestore != nil {
+		log.Error().Err(errRestore).Msg("Failed to restore rewritten references")
+		return errRestore
+	}
+	progressBar, errProgress := pterm.DefaultProgressbar.WithTotal(len(plan.Operations)).Start()
+	if errProgress != nil {
+		log.Error().Err(errProgress).Msg("Failed to start progress bar")
+		return errProgress
+	}
+	_, _, errApply := plan.apply(func() {
 		progressBar.Increment()
-		return errRename
 	})
-	if errWalk != nil {
-		log.Error().Err(errWalk).Msg("Failed to walk directory")
-		return errWalk
+	if errApply != nil {
+		log.Error().Err(errApply).Msg("Failed to undo renames")
+		return errApply
 	}
-	for _, dir := range dirs {
-		errRename := rename(dir)
-		if errRename != nil {
-			log.Error().Err(errRename).Msg("Failed to rename directory")
-			return errRename
+	removeDirectories(journal.Directories)
+	errMark := markJournalUndone(journalPath)
+	if errMark != nil {
+		log.Error().Err(errMark).Msg("Failed to mark rename journal as undone")
+		return errMark
+	}
+	return nil
+}
+
+func showPlan(plan Plan, format string) error {
+	if format == formatJSON {
+		errJSON := plan.WriteJSON(os.Stdout)
+		if errJSON != nil {
+			log.Error().Err(errJSON).Msg("Failed to write plan")
+			return errJSON
 		}
-		progressBar.Increment()
+	} else {
+		pterm.DefaultSection.Println("Planned renames in " + pterm.LightGreen(plan.Root))
+		errTable := plan.RenderTable()
+		if errTable != nil {
+			log.Error().Err(errTable).Msg("Failed to render table")
+			return errTable
+		}
+		pterm.Info.Printfln("%d of %d entries would be renamed, %d would fail.",
+			plan.Changed(), len(plan.Operations), len(plan.Failed()))
+		if len(plan.Protected) > 0 {
+			pterm.Info.Println("Skipped protected entries: " + plan.protectedDescription())
+		}
+	}
+	if len(plan.Failed()) > 0 {
+		return ErrPlanHasFailures
 	}
 	return nil
 }
@@ -0,0 +1,348 @@
+package edit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const presetURLSafe = "url-safe"
+
+var (
+	ErrUnknownRule   = errors.New("unknown rename rule")
+	ErrUnknownPreset = errors.New("unknown rename preset")
+	ErrInvalidRule   = errors.New("invalid rename rule")
+)
+
+// Target is the file or directory a rule is being applied to. Index is its
+// position in the plan, starting at 1.
+type Target struct {
+	Path  string
+	IsDir bool
+	Index int
+}
+
+type Rule interface {
+	Apply(name string, t Target) string
+	String() string
+}
+
+type Pipeline []Rule
+
+func (p Pipeline) Apply(name string, t Target) string {
+	for _, r := range p {
+		name = r.Apply(name, t)
+	}
+	return name
+}
+
+func (p Pipeline) String() string {
+	specs := make([]string, 0, len(p))
+	for _, r := range p {
+		specs = append(specs, r.String())
+	}
+	return strings.Join(specs, ", ")
+}
+
+func Preset(name string) (Pipeline, error) {
+	switch name {
+	case presetURLSafe:
+		return Pipeline{
+			transliterateRule{fallback: FallbackHash},
+			regexRule{spec: "regex:/ /_/", pattern: regexp.MustCompile(` `), replacement: "_"},
+			caseRule{style: caseLower},
+			regexRule{spec: "regex:/[^a-zA-Z0-9-._~]+//", pattern: regexp.MustCompile(`[^a-zA-Z0-9-._~]+`)},
+			nonEmptyRule{},
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownPreset, name)
+}
+
+// ParseRules turns rule specs such as "lower", "prefix:img_" or "regex:/ +/_/"
+// into a pipeline. Presets are expanded in place.
+func ParseRules(specs []string) (Pipeline, error) {
+	var p Pipeline
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(spec, ":")
+		if name == "preset" {
+			preset, err := Preset(arg)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, preset...)
+			continue
+		}
+		r, err := parseRule(spec, name, arg)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+func parseRule(spec, name, arg string) (Rule, error) {
+	switch name {
+	case caseLower, caseUpper, caseTitle, caseCamel, caseSnake, caseKebab:
+		return caseRule{style: name}, nil
+	case "regex":
+		return parseRegexRule(spec, arg)
+	case "prefix":
+		return prefixRule{text: arg}, nil
+	case "suffix":
+		return suffixRule{text: arg}, nil
+	case "trim":
+		if arg == "" {
+			return trimRule{}, nil
+		}
+		return trimRule{cutset: arg}, nil
+	case "template":
+		return parseTemplate(arg)
+	case "transliterate":
+		return newTransliterateRule(arg)
+	case "non-empty":
+		return nonEmptyRule{}, nil
+	case "fix-extension":
+		return extensionRule{}, nil
+	case "max-length":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%w: %q needs a positive length", ErrInvalidRule, spec)
+		}
+		return maxLengthRule{length: n}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownRule, spec)
+}
+
+// parseRegexRule reads sed style arguments, the first character is the
+// delimiter, e.g. "/[ ]+/_/" or "|a/b|c|".
+func parseRegexRule(spec, arg string) (Rule, error) {
+	if len(arg) < 3 {
+		return nil, fmt.Errorf("%w: %q should look like regex:/pattern/replacement/", ErrInvalidRule, spec)
+	}
+	delimiter := arg[:1]
+	parts := strings.Split(strings.TrimSuffix(arg[1:], delimiter), delimiter)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: %q should look like regex:/pattern/replacement/", ErrInvalidRule, spec)
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidRule, spec, err)
+	}
+	return regexRule{spec: spec, pattern: pattern, replacement: parts[1]}, nil
+}
+
+// LoadRulesFile reads one rule spec per line. Blank lines and lines starting
+// with # are ignored.
+func LoadRulesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var specs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, line)
+	}
+	return specs, scanner.Err()
+}
+
+// splitName separates the extension from a file name. Directories and dot
+// files such as ".env" are treated as having no extension.
+func splitName(name string, isDir bool) (string, string) {
+	if isDir {
+		return name, ""
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+type regexRule struct {
+	spec        string
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func (r regexRule) Apply(name string, _ Target) string {
+	return r.pattern.ReplaceAllString(name, r.replacement)
+}
+
+func (r regexRule) String() string {
+	return r.spec
+}
+
+const (
+	caseLower = "lower"
+	caseUpper = "upper"
+	caseTitle = "title"
+	caseCamel = "camel"
+	caseSnake = "snake"
+	caseKebab = "kebab"
+)
+
+type caseRule struct {
+	style string
+}
+
+func (r caseRule) Apply(name string, t Target) string {
+	switch r.style {
+	case caseLower:
+		return strings.ToLower(name)
+	case caseUpper:
+		return strings.ToUpper(name)
+	}
+	stem, ext := splitName(name, t.IsDir)
+	dots := stem[:len(stem)-len(strings.TrimLeft(stem, "."))]
+	words := splitWords(stem)
+	for i, w := range words {
+		w = strings.ToLower(w)
+		switch {
+		case r.style == caseTitle, r.style == caseCamel && i > 0:
+			w = upperFirst(w)
+		}
+		words[i] = w
+	}
+	switch r.style {
+	case caseTitle:
+		stem = strings.Join(words, " ")
+	case caseCamel:
+		stem = strings.Join(words, "")
+	case caseSnake:
+		stem = strings.Join(words, "_")
+	case caseKebab:
+		stem = strings.Join(words, "-")
+	}
+	return dots + stem + strings.ToLower(ext)
+}
+
+func (r caseRule) String() string {
+	return r.style
+}
+
+// splitWords breaks a name into words on separators and on lower to upper
+// case boundaries, so "myFile name-2" becomes [my File name 2].
+func splitWords(s string) []string {
+	var words []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = current[:0]
+		}
+	}
+	var prev rune
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			prev = 0
+			continue
+		}
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			flush()
+		}
+		current = append(current, r)
+		prev = r
+	}
+	flush()
+	return words
+}
+
+func upperFirst(s string) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+type prefixRule struct {
+	text string
+}
+
+func (r prefixRule) Apply(name string, _ Target) string {
+	return r.text + name
+}
+
+func (r prefixRule) String() string {
+	return "prefix:" + r.text
+}
+
+// suffixRule inserts its text before the extension.
+type suffixRule struct {
+	text string
+}
+
+func (r suffixRule) Apply(name string, t Target) string {
+	stem, ext := splitName(name, t.IsDir)
+	return stem + r.text + ext
+}
+
+func (r suffixRule) String() string {
+	return "suffix:" + r.text
+}
+
+// trimRule trims whitespace, or the characters in cutset, from both ends of
+// the name without its extension.
+type trimRule struct {
+	cutset string
+}
+
+func (r trimRule) Apply(name string, t Target) string {
+	stem, ext := splitName(name, t.IsDir)
+	if r.cutset == "" {
+		return strings.TrimSpace(stem) + ext
+	}
+	return strings.Trim(stem, r.cutset) + ext
+}
+
+func (r trimRule) String() string {
+	if r.cutset == "" {
+		return "trim"
+	}
+	return "trim:" + r.cutset
+}
+
+// maxLengthRule shortens the name to at most length runes, keeping the
+// extension intact where possible.
+type maxLengthRule struct {
+	length int
+}
+
+func (r maxLengthRule) Apply(name string, t Target) string {
+	runes := []rune(name)
+	if len(runes) <= r.length {
+		return name
+	}
+	stem, ext := splitName(name, t.IsDir)
+	extRunes := []rune(ext)
+	if len(extRunes) >= r.length {
+		return string(runes[:r.length])
+	}
+	stemRunes := []rune(stem)
+	return string(stemRunes[:r.length-len(extRunes)]) + ext
+}
+
+func (r maxLengthRule) String() string {
+	return "max-length:" + strconv.Itoa(r.length)
+}
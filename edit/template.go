@@ -0,0 +1,184 @@
+package edit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"DevToolsCLI/file"
+)
+
+const defaultTimeLayout = "2006-01-02"
+
+type templatePart struct {
+	literal string
+	token   string
+	arg     string
+}
+
+// templateRule builds a new name from tokens such as {name}, {n:04} or
+// {mtime:2006-01-02}. {name} and {ext} refer to the name as it reaches the
+// rule, every other token is read from the file itself. With a match set,
+// {1}, {2}, ... are the capture groups of the match on the name.
+type templateRule struct {
+	spec  string
+	parts []templatePart
+	match *regexp.Regexp
+}
+
+func parseTemplate(tpl string) (templateRule, error) {
+	return parseTemplateMatch(tpl, nil)
+}
+
+func parseTemplateMatch(tpl string, match *regexp.Regexp) (templateRule, error) {
+	rule := templateRule{spec: tpl, match: match}
+	rest := tpl
+	for rest != "" {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			rule.parts = append(rule.parts, templatePart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return rule, fmt.Errorf("%w: unclosed { in template %q", ErrInvalidRule, tpl)
+		}
+		if start > 0 {
+			rule.parts = append(rule.parts, templatePart{literal: rest[:start]})
+		}
+		token, arg, _ := strings.Cut(rest[start+1:start+end], ":")
+		switch token {
+		case "name", "ext", "parent", "type":
+		case "n", "hash":
+			if _, errWidth := templateWidth(arg, 0); errWidth != nil {
+				return rule, fmt.Errorf("%w: {%s:%s} in template %q needs a number", ErrInvalidRule, token, arg, tpl)
+			}
+		case "mtime":
+			if arg == "" {
+				arg = defaultTimeLayout
+			}
+		case "exif":
+			if arg == "" {
+				return rule, fmt.Errorf("%w: {exif} in template %q needs a field such as {exif:DateTimeOriginal}", ErrInvalidRule, tpl)
+			}
+		default:
+			group, errGroup := strconv.Atoi(token)
+			if errGroup != nil {
+				return rule, fmt.Errorf("%w: unknown token {%s} in template %q", ErrInvalidRule, token, tpl)
+			}
+			if match == nil || group < 0 || group > match.NumSubexp() {
+				return rule, fmt.Errorf("%w: {%s} in template %q needs a --match pattern with that many groups", ErrInvalidRule, token, tpl)
+			}
+		}
+		rule.parts = append(rule.parts, templatePart{token: token, arg: arg})
+		rest = rest[start+end+1:]
+	}
+	return rule, nil
+}
+
+func templateWidth(arg string, fallback int) (int, error) {
+	if arg == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(arg)
+}
+
+func (r templateRule) Apply(name string, t Target) string {
+	var b strings.Builder
+	var exif map[string]string
+	var captures []string
+	if r.match != nil {
+		captures = r.match.FindStringSubmatch(name)
+	}
+	for _, part := range r.parts {
+		if part.token == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		if part.token == "exif" && exif == nil {
+			exif = readExif(t)
+		}
+		value := r.expand(part, name, t, exif, captures)
+		b.WriteString(strings.ReplaceAll(value, string(filepath.Separator), "-"))
+	}
+	return b.String()
+}
+
+func (r templateRule) expand(part templatePart, name string, t Target, exif map[string]string, captures []string) string {
+	stem, ext := splitName(name, t.IsDir)
+	switch part.token {
+	case "name":
+		return stem
+	case "ext":
+		return ext
+	case "parent":
+		return filepath.Base(filepath.Dir(t.Path))
+	case "n":
+		width, _ := templateWidth(part.arg, 0)
+		return fmt.Sprintf("%0*d", width, t.Index)
+	case "mtime":
+		info, errStat := os.Stat(t.Path)
+		if errStat != nil {
+			log.Error().Err(errStat).Str("path", t.Path).Msg("Failed to get modification time")
+			return ""
+		}
+		return info.ModTime().Format(part.arg)
+	case "type":
+		if t.IsDir {
+			return "directory"
+		}
+		fileType, errType := file.GetFileTypeFromFilePath(t.Path)
+		if errType != nil {
+			return string(file.TypeUnknown)
+		}
+		return fileType.Extension
+	case "hash":
+		sum, errHash := hashSource(t.Path, t.IsDir)
+		if errHash != nil {
+			log.Error().Err(errHash).Str("path", t.Path).Msg("Failed to hash file")
+			return ""
+		}
+		width, _ := templateWidth(part.arg, 8)
+		if width <= 0 || width > len(sum) {
+			width = len(sum)
+		}
+		return sum[:width]
+	case "exif":
+		field, layout, hasLayout := strings.Cut(part.arg, ":")
+		value := exif[field]
+		if !hasLayout || value == "" {
+			return value
+		}
+		parsed, errParse := time.Parse(file.ExifTimeLayout, value)
+		if errParse != nil {
+			return value
+		}
+		return parsed.Format(layout)
+	}
+	if group, errGroup := strconv.Atoi(part.token); errGroup == nil && group < len(captures) {
+		return captures[group]
+	}
+	return ""
+}
+
+func readExif(t Target) map[string]string {
+	if t.IsDir {
+		return map[string]string{}
+	}
+	exif, errExif := file.ReadExif(t.Path)
+	if errExif != nil {
+		log.Debug().Err(errExif).Str("path", t.Path).Msg("No exif data")
+		return map[string]string{}
+	}
+	return exif
+}
+
+func (r templateRule) String() string {
+	return "template:" + r.spec
+}
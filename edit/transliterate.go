@@ -0,0 +1,158 @@
+package edit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	FallbackHash   = "hash"
+	FallbackEncode = "encode"
+)
+
+// transliterations covers letters that don't decompose into ASCII, mostly
+// Greek and Cyrillic plus a few Latin ligatures and special letters. Keys are
+// lower case, upper case letters are looked up lower cased and capitalised.
+var transliterations = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i", 'ħ': "h",
+	'ŀ': "l", 'ŧ': "t", 'ŋ': "ng", 'ĸ': "k",
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k",
+	'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t",
+	'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+	'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz", 'ѓ': "gj", 'ќ': "kj", 'ѕ': "dz",
+}
+
+// transliterateRule maps accented Latin, Greek and Cyrillic letters to ASCII.
+// Letters from scripts that can't be transliterated, such as CJK, are replaced
+// using the fallback so the name stays unique and never ends up empty.
+type transliterateRule struct {
+	fallback string
+}
+
+func newTransliterateRule(fallback string) (transliterateRule, error) {
+	switch fallback {
+	case "":
+		return transliterateRule{fallback: FallbackHash}, nil
+	case FallbackHash, FallbackEncode:
+		return transliterateRule{fallback: fallback}, nil
+	}
+	return transliterateRule{}, fmt.Errorf("%w: transliterate fallback must be %s or %s", ErrInvalidRule, FallbackHash, FallbackEncode)
+}
+
+func (r transliterateRule) Apply(name string, _ Target) string {
+	var b strings.Builder
+	var untranslated []rune
+	flush := func() {
+		if len(untranslated) == 0 {
+			return
+		}
+		b.WriteString(r.replace(untranslated))
+		untranslated = untranslated[:0]
+	}
+	for _, c := range name {
+		if c <= unicode.MaxASCII {
+			flush()
+			b.WriteRune(c)
+			continue
+		}
+		ascii, ok := transliterateRune(c)
+		if !ok {
+			untranslated = append(untranslated, c)
+			continue
+		}
+		flush()
+		b.WriteString(ascii)
+	}
+	flush()
+	return b.String()
+}
+
+func (r transliterateRule) replace(runes []rune) string {
+	if r.fallback == FallbackEncode {
+		var b strings.Builder
+		for _, c := range runes {
+			_, _ = fmt.Fprintf(&b, "u%04x", c)
+		}
+		return b.String()
+	}
+	sum := sha256.Sum256([]byte(string(runes)))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+func (r transliterateRule) String() string {
+	return "transliterate:" + r.fallback
+}
+
+// transliterateRune returns the ASCII form of c. Spaces, dashes and other
+// punctuation are always transliterated, only letters and numbers can fail.
+func transliterateRune(c rune) (string, bool) {
+	switch {
+	case unicode.IsSpace(c):
+		return " ", true
+	case unicode.Is(unicode.Pd, c):
+		return "-", true
+	case !unicode.IsLetter(c) && !unicode.IsNumber(c):
+		return "", true
+	}
+	if ascii, ok := lookupTransliteration(c); ok {
+		return ascii, true
+	}
+	var b strings.Builder
+	for _, d := range norm.NFD.String(string(c)) {
+		switch {
+		case unicode.Is(unicode.Mn, d):
+		case d <= unicode.MaxASCII:
+			b.WriteRune(d)
+		default:
+			ascii, ok := lookupTransliteration(d)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(ascii)
+		}
+	}
+	return b.String(), b.Len() > 0
+}
+
+func lookupTransliteration(c rune) (string, bool) {
+	lower := unicode.ToLower(c)
+	ascii, ok := transliterations[lower]
+	if !ok || lower == c {
+		return ascii, ok
+	}
+	return upperFirst(ascii), true
+}
+
+// nonEmptyRule gives entries whose name was reduced to nothing, or to only an
+// extension, a name made from a hash of their original name.
+type nonEmptyRule struct{}
+
+func (r nonEmptyRule) Apply(name string, t Target) string {
+	stem, ext := splitName(name, t.IsDir)
+	_, originalExt := splitName(filepath.Base(t.Path), t.IsDir)
+	if originalExt != "" && strings.EqualFold(name, originalExt) {
+		stem, ext = "", name
+	}
+	if strings.Trim(stem, "._-~") != "" {
+		return name
+	}
+	sum := sha256.Sum256([]byte(filepath.Base(t.Path)))
+	return hex.EncodeToString(sum[:])[:8] + ext
+}
+
+func (r nonEmptyRule) String() string {
+	return "non-empty"
+}
@@ -0,0 +1,219 @@
+package encode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+const (
+	vp8xFlagAnimation = 0x02
+	vp8xFlagAlpha     = 0x10
+	anmfNoBlend       = 0x02
+	anmfMaxDuration   = 1<<24 - 1
+)
+
+// animationFrame is one ANMF chunk, offsets are always even.
+type animationFrame struct {
+	bounds   image.Rectangle
+	duration int
+	data     []byte
+}
+
+// encodeAnimatedLossless writes an animated GIF as an animated lossless WebP.
+// The GIF frames are composited with their disposal methods first, so every
+// WebP frame replaces its area of the canvas without blending and without
+// disposal. With minSize a frame only covers the area that changed since the
+// previous one and frames without changes extend the previous frame. Every
+// composited frame is resized with resize.
+func encodeAnimatedLossless(w io.Writer, g *gif.GIF, minSize bool, resize Resize) error {
+	canvasBounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if canvasBounds.Empty() {
+		for _, frame := range g.Image {
+			canvasBounds = canvasBounds.Union(frame.Bounds())
+		}
+		canvasBounds.Min = image.Point{}
+	}
+	if canvasBounds.Dx() > vp8lMaxDimension || canvasBounds.Dy() > vp8lMaxDimension || canvasBounds.Empty() {
+		return ErrImageTooLarge
+	}
+
+	_, outputWidth, outputHeight, _ := resize.dimensions(canvasBounds.Dx(), canvasBounds.Dy())
+	outputBounds := image.Rect(0, 0, outputWidth, outputHeight)
+
+	canvas := image.NewNRGBA(canvasBounds)
+	var previous *image.NRGBA
+	var frames []animationFrame
+	hasAlpha := false
+	for i, frame := range g.Image {
+		var restore *image.NRGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			restore = cloneNRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		duration := 0
+		if i < len(g.Delay) {
+			duration = g.Delay[i] * 10
+		}
+		output := canvas
+		if resized, isNRGBA := resize.apply(canvas).(*image.NRGBA); isNRGBA {
+			output = resized
+		}
+		area := outputBounds
+		if minSize && previous != nil {
+			changed := changedBounds(previous, output)
+			if changed.Empty() {
+				last := &frames[len(frames)-1]
+				last.duration = clampDuration(last.duration + duration)
+				continue
+			}
+			area = image.Rect(changed.Min.X&^1, changed.Min.Y&^1, changed.Max.X, changed.Max.Y)
+		}
+		data, frameAlpha, errEncode := vp8lBitstream(output.SubImage(area))
+		if errEncode != nil {
+			return errEncode
+		}
+		hasAlpha = hasAlpha || frameAlpha
+		frames = append(frames, animationFrame{bounds: area, duration: clampDuration(duration), data: data})
+		if minSize {
+			previous = cloneNRGBA(output)
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = restore
+		}
+	}
+	return writeAnimatedWebP(w, outputBounds, webpLoopCount(g.LoopCount), hasAlpha, frames)
+}
+
+// webpLoopCount converts a GIF loop count, where 0 loops forever, -1 plays
+// once and n repeats n times, to the number of times WebP plays the
+// animation, where 0 loops forever.
+func webpLoopCount(gifLoopCount int) int {
+	switch {
+	case gifLoopCount == 0:
+		return 0
+	case gifLoopCount < 0:
+		return 1
+	case gifLoopCount+1 > 1<<16-1:
+		return 1<<16 - 1
+	default:
+		return gifLoopCount + 1
+	}
+}
+
+func clampDuration(duration int) int {
+	if duration > anmfMaxDuration {
+		return anmfMaxDuration
+	}
+	return duration
+}
+
+func cloneNRGBA(img *image.NRGBA) *image.NRGBA {
+	clone := image.NewNRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// changedBounds returns the smallest rectangle containing every pixel that
+// differs between a and b.
+func changedBounds(a, b *image.NRGBA) image.Rectangle {
+	bounds := a.Bounds()
+	changed := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := a.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if a.Pix[offset] != b.Pix[offset] || a.Pix[offset+1] != b.Pix[offset+1] ||
+				a.Pix[offset+2] != b.Pix[offset+2] || a.Pix[offset+3] != b.Pix[offset+3] {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+			offset += 4
+		}
+	}
+	return changed
+}
+
+func writeAnimatedWebP(w io.Writer, canvas image.Rectangle, loopCount int, hasAlpha bool, frames []animationFrame) error {
+	vp8x := make([]byte, 10)
+	vp8x[0] = vp8xFlagAnimation
+	if hasAlpha {
+		vp8x[0] |= vp8xFlagAlpha
+	}
+	putUint24(vp8x[4:], canvas.Dx()-1)
+	putUint24(vp8x[7:], canvas.Dy()-1)
+
+	// The background color is transparent and stored as BGRA.
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(loopCount))
+
+	riffSize := 4 + chunkLength(len(vp8x)) + chunkLength(len(anim))
+	for _, frame := range frames {
+		riffSize += chunkLength(16 + chunkLength(len(frame.data)))
+	}
+
+	buffered := bufio.NewWriter(w)
+	header := make([]byte, 12)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(riffSize))
+	copy(header[8:], "WEBP")
+	if _, errWrite := buffered.Write(header); errWrite != nil {
+		return errWrite
+	}
+	if errWrite := writeChunk(buffered, "VP8X", vp8x); errWrite != nil {
+		return errWrite
+	}
+	if errWrite := writeChunk(buffered, "ANIM", anim); errWrite != nil {
+		return errWrite
+	}
+	for _, frame := range frames {
+		anmf := make([]byte, 16, 16+chunkLength(len(frame.data)))
+		putUint24(anmf[0:], frame.bounds.Min.X/2)
+		putUint24(anmf[3:], frame.bounds.Min.Y/2)
+		putUint24(anmf[6:], frame.bounds.Dx()-1)
+		putUint24(anmf[9:], frame.bounds.Dy()-1)
+		putUint24(anmf[12:], frame.duration)
+		anmf[15] = anmfNoBlend
+		anmf = appendChunk(anmf, "VP8L", frame.data)
+		if errWrite := writeChunk(buffered, "ANMF", anmf); errWrite != nil {
+			return errWrite
+		}
+	}
+	return buffered.Flush()
+}
+
+// chunkLength is the size of a RIFF chunk including its header and padding.
+func chunkLength(size int) int {
+	return 8 + size + size&1
+}
+
+func appendChunk(b []byte, fourCC string, data []byte) []byte {
+	b = append(b, fourCC...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)&1 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func writeChunk(w io.Writer, fourCC string, data []byte) error {
+	_, errWrite := w.Write(appendChunk(make([]byte, 0, chunkLength(len(data))), fourCC, data))
+	return errWrite
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
@@ -0,0 +1,155 @@
+package encode
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/pterm/pterm"
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+)
+
+var subCommandAVIF = &cli.Command{
+	Name:        "avif",
+	Description: "Encode jpegs and pngs to avif with avifenc.",
+	Flags: batchFlags(FormatAVIF,
+		&cli.BoolFlag{
+			Name:     "lossless",
+			Required: false,
+			Usage:    "enable lossless encoding",
+			Value:    false,
+		},
+		&cli.IntFlag{
+			Name:     "quality",
+			Required: false,
+			Aliases:  []string{"q"},
+			Usage:    "quality 0-100",
+			Value:    60,
+		},
+		&cli.IntFlag{
+			Name:     "speed",
+			Required: false,
+			Usage:    "encoder speed 0-10, slower speeds make smaller files",
+			Value:    6,
+		},
+	),
+	Action: AVIF,
+}
+
+type avifencEncoder struct {
+	speed int
+}
+
+func (avifencEncoder) Name() string {
+	return "avifenc"
+}
+
+func (avifencEncoder) Available() bool {
+	return commandAvailable("avifenc")
+}
+
+func (avifencEncoder) Supports(j Job) bool {
+	return j.Type == file.TypeJpeg || j.Type == file.TypePng
+}
+
+func (e avifencEncoder) Settings(_ Job, opts EncodeOptions) string {
+	if opts.Lossless {
+		return fmt.Sprintf("avifenc lossless speed=%d", e.speed)
+	}
+	return fmt.Sprintf("avifenc q=%d speed=%d", opts.Quality, e.speed)
+}
+
+func (e avifencEncoder) Encode(f Job, opts EncodeOptions) error {
+	args := []string{"-s", strconv.Itoa(e.speed)}
+	if opts.Lossless {
+		args = append(args, "--lossless")
+	} else {
+		args = append(args, "-q", strconv.Itoa(opts.Quality))
+	}
+	args = append(args, f.InputPath, f.OutputPath)
+	// avifenc has no quiet mode, its progress output would break the
+	// progress bar.
+	cmd := exec.Command("avifenc", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+type AVIFHandler struct {
+	*Batch
+	Lossless bool
+	Quality  int
+	Encoder  avifencEncoder
+}
+
+func AVIF(c *cli.Context) error {
+	encoder := avifencEncoder{speed: c.Int("speed")}
+	if !encoder.Available() {
+		return fmt.Errorf("%w: %s", ErrBackendUnavailable, encoder.Name())
+	}
+	batch, errBatch := newBatch(c, FormatAVIF)
+	if errBatch != nil {
+		return errBatch
+	}
+	avifHandler := &AVIFHandler{
+		Batch:    batch,
+		Lossless: c.Bool("lossless"),
+		Quality:  c.Int("quality"),
+		Encoder:  encoder,
+	}
+	return avifHandler.Run()
+}
+
+func (a *AVIFHandler) Run() error {
+	return a.Batch.Run(a)
+}
+
+func (a *AVIFHandler) prepare() error {
+	return nil
+}
+
+func (a *AVIFHandler) finish() error {
+	return nil
+}
+
+func (a *AVIFHandler) plan(f file.InputOutputInfo) (*filePlan, error) {
+	job := Job{InputOutputInfo: f}
+	p := &filePlan{
+		InputOutputInfo: f,
+		settings:        "avif " + a.Encoder.Settings(job, a.options()),
+		outputs:         []string{f.OutputPath},
+		backend:         a.Encoder.Name(),
+	}
+	if !a.Lossless {
+		quality := a.Quality
+		p.quality = &quality
+	}
+	return p, nil
+}
+
+func (a *AVIFHandler) options() EncodeOptions {
+	return EncodeOptions{Quality: a.Quality, Lossless: a.Lossless}
+}
+
+func (a *AVIFHandler) skipFile(*filePlan) error {
+	return nil
+}
+
+func (a *AVIFHandler) settings() pterm.TableData {
+	return pterm.TableData{
+		{"Lossless Enabled", fmt.Sprintf("%t", a.Lossless)},
+		{"Quality", strconv.Itoa(a.Quality)},
+		{"Speed", strconv.Itoa(a.Encoder.speed)},
+		{"Backend", a.Encoder.Name()},
+	}
+}
+
+func (a *AVIFHandler) encodeFile(p *filePlan) error {
+	return a.Encoder.Encode(Job{InputOutputInfo: p.InputOutputInfo}, a.options())
+}
+
+func (a *AVIFHandler) summary() pterm.TableData {
+	return nil
+}
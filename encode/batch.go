@@ -0,0 +1,454 @@
+package encode
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"golang.org/x/sync/errgroup"
+
+	"DevToolsCLI/file"
+	"DevToolsCLI/prompt"
+)
+
+// Format is an output format of the encode command.
+type Format struct {
+	Name      string
+	Extension string
+	GIFs      bool
+}
+
+var (
+	FormatWebP = Format{Name: "WebP", Extension: ".webp", GIFs: true}
+	FormatAVIF = Format{Name: "AVIF", Extension: ".avif", GIFs: false}
+	FormatJXL  = Format{Name: "JPEG XL", Extension: ".jxl", GIFs: true}
+)
+
+// formatHandler is the format specific part of a batch.
+type formatHandler interface {
+	prepare() error
+	settings() pterm.TableData
+	// plan resolves how f is encoded. It runs once per file, encodeFile,
+	// skipFile, the cache and the report all use its result.
+	plan(f file.InputOutputInfo) (*filePlan, error)
+	encodeFile(p *filePlan) error
+	// skipFile is called instead of encodeFile when the outputs of p are up
+	// to date.
+	skipFile(p *filePlan) error
+	finish() error
+	summary() pterm.TableData
+}
+
+// filePlan is how one source is encoded: the encoder picked for each output
+// and the settings it is encoded with. A change of settings or outputs means
+// the source has to be encoded again.
+type filePlan struct {
+	file.InputOutputInfo
+	settings string
+	outputs  []string
+	backend  string
+	// quality is left out for lossless files and set by encodeFile when it is
+	// searched.
+	quality *int
+	steps   []encodeStep
+	// width and height of the source, for handlers that measure it.
+	width  int
+	height int
+}
+
+// encodeStep writes one output of a source.
+type encodeStep struct {
+	job      Job
+	encoder  Encoder
+	opts     EncodeOptions
+	searched bool
+	width    int
+	height   int
+}
+
+// batchFlags are the flags every encode subcommand shares, followed by the
+// format specific flags.
+func batchFlags(format Format, flags ...cli.Flag) []cli.Flag {
+	shared := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "input",
+			Required:    true,
+			DefaultText: "the directory you want to encode the " + format.Extension[1:] + " files from",
+		},
+		&cli.StringFlag{
+			Name:     "output",
+			Required: true,
+			Usage:    "the directory you want to output the " + format.Extension[1:] + " files to",
+		},
+		&cli.BoolFlag{
+			Name:     "jpegs",
+			Required: false,
+			Usage:    "encode jpegs",
+			Value:    false,
+		},
+		&cli.BoolFlag{
+			Name:     "pngs",
+			Required: false,
+			Usage:    "encode pngs",
+			Value:    false,
+		},
+	}
+	if format.GIFs {
+		shared = append(shared, &cli.BoolFlag{
+			Name:     "gifs",
+			Required: false,
+			Usage:    "encode gifs",
+			Value:    false,
+		})
+	}
+	shared = append(shared, &cli.BoolFlag{
+		Name:     "incremental",
+		Required: false,
+		Usage:    "skip files that haven't changed since the last run with the same settings",
+		Value:    false,
+	}, &cli.StringFlag{
+		Name:     "report",
+		Required: false,
+		Usage:    "write a row for every file with its sizes, duration, backend and settings to this .json or .csv file",
+	})
+	shared = append(shared, flags...)
+	return append(shared, file.FilterFlags()...)
+}
+
+// Batch encodes every enabled image in the input directory into the output
+// directory, mirroring its subdirectories.
+type Batch struct {
+	Format              Format
+	InputDirectoryInfo  file.DirectoryInfo
+	OutputDirectoryInfo file.DirectoryInfo
+	AbsoluteInputPath   string
+	AbsoluteOutputPath  string
+	JpegsEnabled        bool
+	PngsEnabled         bool
+	GifsEnabled         bool
+	Filter              file.Filter
+	Incremental         bool
+	Watch               bool
+	Debounce            time.Duration
+	Confirm             func(question string, defaultValue bool) (bool, error)
+
+	cache       *encodeCache
+	report      *encodeReport
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	written     writtenOutputs
+}
+
+func newBatch(c *cli.Context, format Format) (*Batch, error) {
+	inputDirectory := c.String("input")
+	outputDirectory := c.String("output")
+	jpegs := c.Bool("jpegs")
+	pngs := c.Bool("pngs")
+	gifs := format.GIFs && c.Bool("gifs")
+	if !c.IsSet("jpegs") && !c.IsSet("pngs") && !(format.GIFs && c.IsSet("gifs")) {
+		jpegs = true
+		pngs = true
+		gifs = format.GIFs
+	}
+	filter := file.FilterFromContext(c)
+	absoluteInputPath, err := filepath.Abs(inputDirectory)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting absolute path of input directory")
+		return nil, err
+	}
+	absoluteOutputPath, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting absolute path of output directory")
+		return nil, err
+	}
+
+	batch := &Batch{
+		Format:             format,
+		AbsoluteInputPath:  absoluteInputPath,
+		AbsoluteOutputPath: absoluteOutputPath,
+		JpegsEnabled:       jpegs,
+		PngsEnabled:        pngs,
+		GifsEnabled:        gifs,
+		Filter:             filter,
+		Incremental:        c.Bool("incremental"),
+		Confirm: func(question string, defaultValue bool) (bool, error) {
+			return prompt.Confirm(c, question, defaultValue)
+		},
+	}
+
+	inputDirectoryInfo, err := file.GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, absoluteInputPath, format.Extension, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting input directory info")
+		return nil, err
+	}
+	outputDirectoryInfo, err := file.GetDirectoryInfoIO(absoluteOutputPath, absoluteOutputPath, absoluteOutputPath, format.Extension, file.Filter{})
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting output directory info")
+		return nil, err
+	}
+	batch.InputDirectoryInfo = inputDirectoryInfo
+	batch.OutputDirectoryInfo = outputDirectoryInfo
+	if reportPath := c.String("report"); reportPath != "" {
+		batch.report, err = newReport(reportPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating the report")
+			return nil, err
+		}
+	}
+	if batch.Incremental {
+		batch.cache, err = loadCache(format, absoluteOutputPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Error loading the encode cache")
+			return nil, err
+		}
+	}
+
+	errOutput := batch.createOutputDirectoriesFromInputSubDirectories()
+	if errOutput != nil {
+		log.Error().Err(errOutput).Msg("Error creating output directories")
+		return nil, errOutput
+	}
+	return batch, nil
+}
+
+func (b *Batch) createOutputDirectoriesFromInputSubDirectories() error {
+	for _, subDir := range b.InputDirectoryInfo.SubDirectories {
+		outputSubDir := file.GetTrunkedOutputPath(b.AbsoluteInputPath, b.AbsoluteOutputPath, subDir, b.Format.Extension, true)
+		err := os.MkdirAll(outputSubDir, 755)
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating output sub directory")
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Batch) enabled(f file.InputOutputInfo) bool {
+	switch f.Type {
+	case file.TypeJpeg:
+		return b.JpegsEnabled
+	case file.TypePng:
+		return b.PngsEnabled
+	case file.TypeGif:
+		return b.GifsEnabled
+	}
+	return false
+}
+
+// Run shows the settings, asks for confirmation and encodes all enabled
+// files with h, one worker per CPU.
+func (b *Batch) Run(h formatHandler) error {
+	errPrepare := h.prepare()
+	if errPrepare != nil {
+		return errPrepare
+	}
+
+	pterm.DefaultSection.Println("Currently configured encoding settings.")
+	settings := pterm.TableData{
+		{"Input Directory", b.InputDirectoryInfo.Path},
+		{"Output Directory", b.OutputDirectoryInfo.Path},
+		{"Number of Files", strconv.FormatInt(b.InputDirectoryInfo.NumberOfFiles, 10)},
+		{"Number of Directories", strconv.FormatInt(b.InputDirectoryInfo.NumberOfDirectories, 10)},
+		{"Total Size Before Encoding", humanize.Bytes(uint64(b.InputDirectoryInfo.TotalSize))},
+		{"Jpegs Enabled", fmt.Sprintf("%t", b.JpegsEnabled)},
+		{"Jpegs Found", strconv.FormatInt(b.InputDirectoryInfo.JpegCount, 10)},
+		{"Pngs Enabled", fmt.Sprintf("%t", b.PngsEnabled)},
+		{"Pngs Found", strconv.FormatInt(b.InputDirectoryInfo.PngCount, 10)},
+	}
+	if b.Format.GIFs {
+		settings = append(settings,
+			[]string{"Gifs Enabled", fmt.Sprintf("%t", b.GifsEnabled)},
+			[]string{"Gifs Found", strconv.FormatInt(b.InputDirectoryInfo.GifCount, 10)},
+		)
+	}
+	settings = append(settings, h.settings()...)
+	settings = append(settings, []string{"Filters", b.Filter.String()})
+	if b.cache != nil {
+		settings = append(settings, []string{"Incremental", b.cache.path})
+	}
+	if b.report != nil {
+		settings = append(settings, []string{"Report", b.report.path})
+	}
+	if b.Watch {
+		settings = append(settings, []string{"Watch", "debounce " + b.Debounce.String()})
+	}
+	errRender := pterm.DefaultTable.WithData(settings).Render()
+	if errRender != nil {
+		log.Error().Err(errRender).Msg("Error rendering table")
+		return errRender
+	}
+
+	confirmed, errConfirm := b.Confirm("Are you sure you want to continue?", true)
+	if errConfirm != nil {
+		log.Error().Err(errConfirm).Msg("Error confirming")
+		return errConfirm
+	}
+	if !confirmed {
+		return nil
+	}
+
+	numCoresUsed := runtime.NumCPU()
+	startTime := time.Now()
+	wg := new(errgroup.Group)
+	wg.SetLimit(numCoresUsed)
+
+	var totalFilesToProcess int64 = 0
+	if b.JpegsEnabled {
+		totalFilesToProcess += b.InputDirectoryInfo.JpegCount
+	}
+	if b.PngsEnabled {
+		totalFilesToProcess += b.InputDirectoryInfo.PngCount
+	}
+	if b.GifsEnabled {
+		totalFilesToProcess += b.InputDirectoryInfo.GifCount
+	}
+
+	progressBar, errProgress := pterm.DefaultProgressbar.WithTotal(int(totalFilesToProcess)).Start("Encoding files to " + b.Format.Name)
+	if errProgress != nil {
+		log.Error().Err(errProgress).Msg("Error creating progress bar")
+		return errProgress
+	}
+	for _, f := range b.InputDirectoryInfo.KnownIOFiles {
+		f := f
+		if !b.enabled(f) {
+			continue
+		}
+		wg.Go(func() error {
+			_, errEncode := b.encodeFile(h, f)
+			progressBar.Increment()
+			return errEncode
+		})
+	}
+	errWait := wg.Wait()
+	if b.cache != nil {
+		// Files encoded before an error are still up to date next time.
+		errSave := b.cache.save()
+		if errSave != nil {
+			log.Error().Err(errSave).Msg("Error saving the encode cache")
+			return errSave
+		}
+	}
+	// The report also lists the files that failed.
+	reportSummary, errReport := b.writeReport()
+	if errReport != nil {
+		return errReport
+	}
+	if errWait != nil {
+		log.Error().Err(errWait).Msg("Error converting files")
+		return errWait
+	}
+	errFinish := h.finish()
+	if errFinish != nil {
+		return errFinish
+	}
+
+	updatedOutputDirInfo, errOutputDirInfo := file.GetDirectoryInfo(b.AbsoluteOutputPath, file.Filter{})
+	if errOutputDirInfo != nil {
+		log.Error().Err(errOutputDirInfo).Msg("Error getting output directory info")
+		return errOutputDirInfo
+	}
+
+	outputSizeDifference := updatedOutputDirInfo.TotalSize
+	if b.OutputDirectoryInfo.TotalSize > outputSizeDifference {
+		outputSizeDifference = b.OutputDirectoryInfo.TotalSize - updatedOutputDirInfo.TotalSize
+	}
+
+	spaceSaved := b.InputDirectoryInfo.TotalSize - updatedOutputDirInfo.TotalSize
+	if spaceSaved < 0 {
+		spaceSaved = 0
+	}
+
+	totalTimeTaken := time.Since(startTime)
+
+	pterm.Println()
+	pterm.DefaultSection.Println("Encoding Summary")
+	summary := pterm.TableData{
+		{"Total Input File Size Before Encoding", humanize.Bytes(uint64(b.InputDirectoryInfo.TotalSize))},
+		{"Total Output File Size After Encoding", humanize.Bytes(uint64(outputSizeDifference))},
+		{"Total Space Saved", humanize.Bytes(uint64(spaceSaved))},
+	}
+	summary = append(summary, h.summary()...)
+	if b.cache != nil {
+		summary = append(summary,
+			[]string{"Cache Hits", strconv.FormatInt(b.cacheHits.Load(), 10)},
+			[]string{"Cache Misses", strconv.FormatInt(b.cacheMisses.Load(), 10)},
+		)
+	}
+	if b.report != nil {
+		summary = append(summary,
+			[]string{"Compression Ratio p50 / p90", fmt.Sprintf("%.4g / %.4g", reportSummary.Percentiles.Ratio.P50, reportSummary.Percentiles.Ratio.P90)},
+			[]string{"Report", b.report.path},
+		)
+	}
+	summary = append(summary, []string{"Total Time Taken to Encode All Files", totalTimeTaken.String()})
+	errRender = pterm.DefaultTable.WithData(summary).Render()
+	if errRender != nil || !b.Watch {
+		return errRender
+	}
+	return b.watch(h)
+}
+
+// encodeFile encodes f with h and reports whether it was skipped because its
+// outputs were up to date.
+func (b *Batch) encodeFile(h formatHandler, f file.InputOutputInfo) (bool, error) {
+	startTime := time.Now()
+	p, errEncode := h.plan(f)
+	skipped := false
+	if errEncode == nil {
+		skipped, errEncode = b.encodeIfChanged(h, p)
+	}
+	if errEncode == nil {
+		b.written.add(f.InputPath, p.outputs)
+	}
+	if b.report != nil {
+		b.report.add(reportRow(f, p, skipped, time.Since(startTime), errEncode))
+	}
+	return skipped, errEncode
+}
+
+// encodeIfChanged encodes p with h, in incremental mode only when the cache
+// has no up to date outputs for it.
+func (b *Batch) encodeIfChanged(h formatHandler, p *filePlan) (bool, error) {
+	if b.cache == nil {
+		return false, h.encodeFile(p)
+	}
+	entry, upToDate, errCheck := b.cache.check(p.InputPath, p.settings, p.outputs)
+	if errCheck != nil {
+		return false, errCheck
+	}
+	if upToDate {
+		b.cacheHits.Add(1)
+		if p.quality == nil {
+			p.quality = entry.Quality
+		}
+		return true, h.skipFile(p)
+	}
+	b.cacheMisses.Add(1)
+	errEncode := h.encodeFile(p)
+	if errEncode != nil {
+		return false, errEncode
+	}
+	entry.Quality = p.quality
+	b.cache.store(p.InputPath, entry)
+	return false, nil
+}
+
+func (b *Batch) writeReport() (ReportSummary, error) {
+	if b.report == nil {
+		return ReportSummary{}, nil
+	}
+	summary, errWrite := b.report.write(b.AbsoluteInputPath, b.AbsoluteOutputPath)
+	if errWrite != nil {
+		log.Error().Err(errWrite).Msg("Error writing the report")
+	}
+	return summary, errWrite
+}
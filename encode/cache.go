@@ -0,0 +1,150 @@
+package encode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"DevToolsCLI/file"
+)
+
+// encodeCache remembers which sources were encoded with which settings, so
+// incremental runs can skip files whose outputs are up to date. There is one
+// cache per output directory and format.
+type encodeCache struct {
+	path string
+	mu   sync.Mutex
+	// Entries are keyed by the absolute source path.
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+type cacheEntry struct {
+	Hash     string    `json:"hash"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Settings string    `json:"settings"`
+	Outputs  []string  `json:"outputs"`
+	// Quality is the quality a target search chose, for reports of skipped
+	// files.
+	Quality *int `json:"quality,omitempty"`
+}
+
+func cacheDirectory() (string, error) {
+	dir, errState := file.StateDirectory()
+	if errState != nil {
+		return "", errState
+	}
+	return filepath.Join(dir, "encode-cache"), nil
+}
+
+func loadCache(format Format, absoluteOutputPath string) (*encodeCache, error) {
+	dir, errDir := cacheDirectory()
+	if errDir != nil {
+		return nil, errDir
+	}
+	key := sha256.Sum256([]byte(format.Extension + "\x00" + absoluteOutputPath))
+	c := &encodeCache{
+		path:    filepath.Join(dir, hex.EncodeToString(key[:8])+".json"),
+		Entries: map[string]cacheEntry{},
+	}
+	data, errRead := os.ReadFile(c.path)
+	if errors.Is(errRead, fs.ErrNotExist) {
+		return c, nil
+	}
+	if errRead != nil {
+		return nil, errRead
+	}
+	if errUnmarshal := json.Unmarshal(data, c); errUnmarshal != nil {
+		// A broken cache only costs a full run.
+		c.Entries = map[string]cacheEntry{}
+	}
+	return c, nil
+}
+
+// check reports whether the outputs of source are up to date and returns the
+// current state of the source for store, with the cached quality when they
+// are. The content hash is only computed
+// again when the size or modification time changed.
+func (c *encodeCache) check(source, settings string, outputs []string) (cacheEntry, bool, error) {
+	info, errStat := os.Stat(source)
+	if errStat != nil {
+		return cacheEntry{}, false, errStat
+	}
+	c.mu.Lock()
+	cached, found := c.Entries[source]
+	c.mu.Unlock()
+
+	current := cacheEntry{Size: info.Size(), ModTime: info.ModTime(), Settings: settings, Outputs: outputs}
+	if found && cached.Size == current.Size && cached.ModTime.Equal(current.ModTime) {
+		current.Hash = cached.Hash
+	} else {
+		hash, errHash := hashFile(source)
+		if errHash != nil {
+			return current, false, errHash
+		}
+		current.Hash = hash
+	}
+	if !found || cached.Hash != current.Hash || cached.Settings != settings || !sameStrings(cached.Outputs, outputs) {
+		return current, false, nil
+	}
+	for _, output := range outputs {
+		if _, errOutput := os.Stat(output); errOutput != nil {
+			return current, false, nil
+		}
+	}
+	current.Quality = cached.Quality
+	return current, true, nil
+}
+
+func (c *encodeCache) store(source string, entry cacheEntry) {
+	c.mu.Lock()
+	c.Entries[source] = entry
+	c.mu.Unlock()
+}
+
+func (c *encodeCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if errMkdir := os.MkdirAll(filepath.Dir(c.path), 0o755); errMkdir != nil {
+		return errMkdir
+	}
+	data, errMarshal := json.Marshal(c)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return os.WriteFile(c.path, data, 0o644)
+}
+
+func hashFile(path string) (string, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return "", errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	if _, errCopy := io.Copy(h, f); errCopy != nil {
+		return "", errCopy
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
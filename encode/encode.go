@@ -7,6 +7,9 @@ func Command() *cli.Command {
 		Name: "encode",
 		Subcommands: []*cli.Command{
 			subCommandWebP,
+			subCommandAVIF,
+			subCommandJXL,
+			subCommandResponsive,
 		},
 	}
 }
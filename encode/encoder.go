@@ -0,0 +1,334 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"DevToolsCLI/file"
+)
+
+const (
+	BackendAuto     = "auto"
+	BackendCWebP    = "cwebp"
+	BackendGif2WebP = "gif2webp"
+	BackendNative   = "native"
+)
+
+var (
+	ErrUnknownBackend     = errors.New("unknown encoder backend")
+	ErrBackendUnavailable = errors.New("encoder backend isn't installed")
+	ErrBackendUnsupported = errors.New("encoder backend can't encode this file type")
+	ErrMixedLossless      = errors.New("--mixed and --lossless can't be combined")
+)
+
+// EncodeOptions are the encoder settings. MinSize, KMin and KMax only apply
+// to animations, a negative KMin or KMax keeps the encoder's default.
+type EncodeOptions struct {
+	Quality  int
+	Lossless bool
+	Mixed    bool
+	MinSize  bool
+	KMin     int
+	KMax     int
+	Resize   Resize
+}
+
+// Job is a single file to encode. Resize is set when the image is larger
+// than the resize limits.
+type Job struct {
+	file.InputOutputInfo
+	Animated bool
+	Resize   bool
+}
+
+// Encoder turns a single image into a WebP file. Settings describes the
+// options that change what Encode writes for j, for cache keys and reports.
+type Encoder interface {
+	Name() string
+	Available() bool
+	Supports(j Job) bool
+	Encode(j Job, opts EncodeOptions) error
+	Settings(j Job, opts EncodeOptions) string
+}
+
+var encoders = []Encoder{cwebpEncoder{}, gif2webpEncoder{}, nativeEncoder{}}
+
+func commandAvailable(name string) bool {
+	_, errLook := exec.LookPath(name)
+	return errLook == nil
+}
+
+func runEncoderCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+type cwebpEncoder struct{}
+
+func (cwebpEncoder) Name() string {
+	return BackendCWebP
+}
+
+func (cwebpEncoder) Available() bool {
+	return commandAvailable("cwebp")
+}
+
+// Supports excludes animated GIFs, cwebp would only keep their first frame.
+func (cwebpEncoder) Supports(j Job) bool {
+	return (j.Type == file.TypeJpeg || j.Type == file.TypePng || j.Type == file.TypeGif) && !j.Animated
+}
+
+func (cwebpEncoder) Settings(_ Job, opts EncodeOptions) string {
+	if opts.Lossless {
+		return "cwebp lossless " + qualitySetting(opts.Quality)
+	}
+	return "cwebp " + qualitySetting(opts.Quality)
+}
+
+func (cwebpEncoder) Encode(f Job, opts EncodeOptions) error {
+	input := f.InputPath
+	if f.Resize {
+		resized, errResize := resizedCopy(f.InputPath, opts.Resize)
+		if errResize != nil {
+			return errResize
+		}
+		defer func() {
+			_ = os.Remove(resized)
+		}()
+		input = resized
+	}
+	var args []string
+	if opts.Lossless {
+		args = append(args, "-lossless")
+	}
+	args = append(args, "-q", fmt.Sprintf("%d", opts.Quality), "-mt", input, "-o", f.OutputPath, "-quiet")
+	return runEncoderCommand("cwebp", args...)
+}
+
+type gif2webpEncoder struct{}
+
+func (gif2webpEncoder) Name() string {
+	return BackendGif2WebP
+}
+
+func (gif2webpEncoder) Available() bool {
+	return commandAvailable("gif2webp")
+}
+
+// Supports excludes GIFs that have to be resized, gif2webp can't resize.
+func (gif2webpEncoder) Supports(j Job) bool {
+	return j.Type == file.TypeGif && !j.Resize
+}
+
+func (gif2webpEncoder) Settings(_ Job, opts EncodeOptions) string {
+	settings := "gif2webp lossy"
+	switch {
+	case opts.Mixed:
+		settings = "gif2webp mixed"
+	case opts.Lossless:
+		settings = "gif2webp lossless"
+	}
+	settings += " " + qualitySetting(opts.Quality)
+	if opts.MinSize {
+		settings += " min-size"
+	}
+	if opts.KMin >= 0 {
+		settings += fmt.Sprintf(" kmin=%d", opts.KMin)
+	}
+	if opts.KMax >= 0 {
+		settings += fmt.Sprintf(" kmax=%d", opts.KMax)
+	}
+	return settings
+}
+
+func (gif2webpEncoder) Encode(f Job, opts EncodeOptions) error {
+	var args []string
+	switch {
+	case opts.Mixed:
+		args = append(args, "-mixed")
+	case !opts.Lossless:
+		args = append(args, "-lossy")
+	}
+	if opts.MinSize {
+		args = append(args, "-min_size")
+	}
+	if opts.KMin >= 0 {
+		args = append(args, "-kmin", strconv.Itoa(opts.KMin))
+	}
+	if opts.KMax >= 0 {
+		args = append(args, "-kmax", strconv.Itoa(opts.KMax))
+	}
+	args = append(args, "-q", fmt.Sprintf("%d", opts.Quality), "-mt", f.InputPath, "-o", f.OutputPath, "-quiet")
+	return runEncoderCommand("gif2webp", args...)
+}
+
+// nativeEncoder needs no external binaries. It only writes lossless WebP, the
+// quality and mixed settings don't apply.
+type nativeEncoder struct{}
+
+func (nativeEncoder) Name() string {
+	return BackendNative
+}
+
+func (nativeEncoder) Available() bool {
+	return true
+}
+
+func (nativeEncoder) Supports(j Job) bool {
+	return j.Type == file.TypeJpeg || j.Type == file.TypePng || j.Type == file.TypeGif
+}
+
+// Settings leaves out the quality, the native encoder doesn't use it.
+func (nativeEncoder) Settings(j Job, opts EncodeOptions) string {
+	if j.Animated && opts.MinSize {
+		return "native lossless min-size"
+	}
+	return "native lossless"
+}
+
+func (nativeEncoder) Encode(f Job, opts EncodeOptions) error {
+	in, errOpen := os.Open(f.InputPath)
+	if errOpen != nil {
+		return errOpen
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	var encode func(w io.Writer) error
+	if f.Animated {
+		g, errDecode := gif.DecodeAll(in)
+		if errDecode != nil {
+			return fmt.Errorf("decoding %s: %w", f.InputPath, errDecode)
+		}
+		encode = func(w io.Writer) error {
+			return encodeAnimatedLossless(w, g, opts.MinSize, opts.Resize)
+		}
+	} else {
+		img, _, errDecode := image.Decode(in)
+		if errDecode != nil {
+			return fmt.Errorf("decoding %s: %w", f.InputPath, errDecode)
+		}
+		encode = func(w io.Writer) error {
+			return encodeLossless(w, opts.Resize.apply(img))
+		}
+	}
+	out, errCreate := os.Create(f.OutputPath)
+	if errCreate != nil {
+		return errCreate
+	}
+	errEncode := encode(out)
+	errClose := out.Close()
+	if errEncode == nil {
+		errEncode = errClose
+	}
+	if errEncode != nil {
+		_ = os.Remove(f.OutputPath)
+		return fmt.Errorf("encoding %s: %w", f.InputPath, errEncode)
+	}
+	return nil
+}
+
+// qualitySetting formats a quality for Settings, a negative quality is
+// searched for every file.
+func qualitySetting(quality int) string {
+	if quality < 0 {
+		return "q=searched"
+	}
+	return fmt.Sprintf("q=%d", quality)
+}
+
+func encoderByName(name string) (Encoder, error) {
+	for _, e := range encoders {
+		if e.Name() == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q, expected %s, %s, %s or %s", ErrUnknownBackend, name, BackendAuto, BackendCWebP, BackendGif2WebP, BackendNative)
+}
+
+// ValidBackend checks that the backend exists and, unless it is chosen
+// automatically, is installed.
+func ValidBackend(name string) error {
+	if name == BackendAuto {
+		return nil
+	}
+	e, errName := encoderByName(name)
+	if errName != nil {
+		return errName
+	}
+	if !e.Available() {
+		return fmt.Errorf("%w: %s", ErrBackendUnavailable, name)
+	}
+	return nil
+}
+
+// selectEncoder returns the encoder for f. A backend that can't encode f
+// only applies to the files it can, f is handled automatically instead.
+// Automatically GIFs go to gif2webp and everything else to cwebp, falling
+// back to the native encoder when those aren't installed. Jpegs and pngs only
+// fall back to it when lossless is asked for, a lossless copy of a photo is
+// usually larger than the original.
+func selectEncoder(backend string, f Job, lossless bool) (Encoder, error) {
+	if backend != BackendAuto {
+		e, errName := encoderByName(backend)
+		if errName != nil {
+			return nil, errName
+		}
+		if e.Supports(f) {
+			return e, nil
+		}
+	}
+	preferred := []Encoder{cwebpEncoder{}}
+	switch {
+	case f.Type == file.TypeGif:
+		preferred = []Encoder{gif2webpEncoder{}, nativeEncoder{}}
+	case lossless:
+		preferred = append(preferred, nativeEncoder{})
+	}
+	for _, e := range preferred {
+		if e.Available() && e.Supports(f) {
+			return e, nil
+		}
+	}
+	if f.Type != file.TypeGif {
+		return nil, fmt.Errorf("%w: %s needs cwebp for lossy encoding", ErrBackendUnavailable, f.InputPath)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBackendUnsupported, f.InputPath)
+}
+
+// skipsLossy reports whether jpegs and pngs are skipped because they would
+// need cwebp, see selectEncoder.
+func skipsLossy(backend string, lossless bool) bool {
+	_, errSelect := selectEncoder(backend, Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeJpeg}}, lossless)
+	return errSelect != nil
+}
+
+// backendDescription describes which encoder handles which file type.
+func backendDescription(backend string, lossless bool) string {
+	name := func(t Job) string {
+		e, errSelect := selectEncoder(backend, t, lossless)
+		if errors.Is(errSelect, ErrBackendUnavailable) {
+			return "skipped, needs cwebp"
+		}
+		if errSelect != nil {
+			return "none"
+		}
+		return e.Name()
+	}
+	return fmt.Sprintf("%s (jpeg: %s, png: %s, gif: %s, animated gif: %s, resized gif: %s)", backend,
+		name(Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeJpeg}}),
+		name(Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypePng}}),
+		name(Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeGif}}),
+		name(Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeGif}, Animated: true}),
+		name(Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeGif}, Resize: true}))
+}
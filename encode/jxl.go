@@ -0,0 +1,179 @@
+package encode
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/pterm/pterm"
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+)
+
+var subCommandJXL = &cli.Command{
+	Name:        "jxl",
+	Description: "Encode jpegs, pngs and gifs to jpeg xl with cjxl. Jpegs are recompressed losslessly unless --recompress-jpegs is set.",
+	Flags: batchFlags(FormatJXL,
+		&cli.BoolFlag{
+			Name:     "lossless",
+			Required: false,
+			Usage:    "enable lossless encoding",
+			Value:    false,
+		},
+		&cli.IntFlag{
+			Name:     "quality",
+			Required: false,
+			Aliases:  []string{"q"},
+			Usage:    "quality 0-100",
+			Value:    90,
+		},
+		&cli.IntFlag{
+			Name:     "effort",
+			Required: false,
+			Usage:    "encoder effort 1-9, higher efforts make smaller files",
+			Value:    7,
+		},
+		&cli.BoolFlag{
+			Name:     "recompress-jpegs",
+			Required: false,
+			Usage:    "encode jpegs with --quality instead of transcoding them losslessly",
+			Value:    false,
+		},
+	),
+	Action: JXL,
+}
+
+type cjxlEncoder struct {
+	effort          int
+	recompressJPEGs bool
+}
+
+func (cjxlEncoder) Name() string {
+	return "cjxl"
+}
+
+func (cjxlEncoder) Available() bool {
+	return commandAvailable("cjxl")
+}
+
+func (cjxlEncoder) Supports(j Job) bool {
+	return j.Type == file.TypeJpeg || j.Type == file.TypePng || j.Type == file.TypeGif
+}
+
+// transcodes reports whether cjxl stores f as a losslessly recompressed jpeg.
+func (e cjxlEncoder) transcodes(f Job) bool {
+	return f.Type == file.TypeJpeg && !e.recompressJPEGs
+}
+
+func (e cjxlEncoder) Settings(f Job, opts EncodeOptions) string {
+	switch {
+	case e.transcodes(f):
+		return fmt.Sprintf("cjxl jpeg transcode effort=%d", e.effort)
+	case opts.Lossless:
+		return fmt.Sprintf("cjxl lossless effort=%d", e.effort)
+	}
+	return fmt.Sprintf("cjxl q=%d effort=%d", opts.Quality, e.effort)
+}
+
+func (e cjxlEncoder) Encode(f Job, opts EncodeOptions) error {
+	args := []string{f.InputPath, f.OutputPath, "-e", strconv.Itoa(e.effort), "--quiet"}
+	switch {
+	case e.transcodes(f):
+	case opts.Lossless:
+		args = append(args, "-d", "0")
+	default:
+		args = append(args, "-q", strconv.Itoa(opts.Quality))
+	}
+	if f.Type == file.TypeJpeg && e.recompressJPEGs {
+		args = append(args, "--lossless_jpeg=0")
+	}
+	return runEncoderCommand("cjxl", args...)
+}
+
+type JXLHandler struct {
+	*Batch
+	Lossless bool
+	Quality  int
+	Encoder  cjxlEncoder
+
+	transcoded atomic.Int64
+}
+
+func JXL(c *cli.Context) error {
+	encoder := cjxlEncoder{effort: c.Int("effort"), recompressJPEGs: c.Bool("recompress-jpegs")}
+	if !encoder.Available() {
+		return fmt.Errorf("%w: %s", ErrBackendUnavailable, encoder.Name())
+	}
+	batch, errBatch := newBatch(c, FormatJXL)
+	if errBatch != nil {
+		return errBatch
+	}
+	jxlHandler := &JXLHandler{
+		Batch:    batch,
+		Lossless: c.Bool("lossless"),
+		Quality:  c.Int("quality"),
+		Encoder:  encoder,
+	}
+	return jxlHandler.Run()
+}
+
+func (j *JXLHandler) Run() error {
+	return j.Batch.Run(j)
+}
+
+func (j *JXLHandler) prepare() error {
+	return nil
+}
+
+func (j *JXLHandler) finish() error {
+	return nil
+}
+
+func (j *JXLHandler) plan(f file.InputOutputInfo) (*filePlan, error) {
+	job := Job{InputOutputInfo: f}
+	p := &filePlan{
+		InputOutputInfo: f,
+		settings:        "jxl " + j.Encoder.Settings(job, j.options()),
+		outputs:         []string{f.OutputPath},
+		backend:         j.Encoder.Name(),
+	}
+	if !j.Lossless && !j.Encoder.transcodes(job) {
+		quality := j.Quality
+		p.quality = &quality
+	}
+	return p, nil
+}
+
+func (j *JXLHandler) options() EncodeOptions {
+	return EncodeOptions{Quality: j.Quality, Lossless: j.Lossless}
+}
+
+func (j *JXLHandler) skipFile(*filePlan) error {
+	return nil
+}
+
+func (j *JXLHandler) settings() pterm.TableData {
+	return pterm.TableData{
+		{"Lossless Enabled", fmt.Sprintf("%t", j.Lossless)},
+		{"Quality", strconv.Itoa(j.Quality)},
+		{"Effort", strconv.Itoa(j.Encoder.effort)},
+		{"Lossless Jpeg Transcoding", fmt.Sprintf("%t", !j.Encoder.recompressJPEGs)},
+		{"Backend", j.Encoder.Name()},
+	}
+}
+
+func (j *JXLHandler) encodeFile(p *filePlan) error {
+	job := Job{InputOutputInfo: p.InputOutputInfo}
+	errEncode := j.Encoder.Encode(job, j.options())
+	if errEncode == nil && j.Encoder.transcodes(job) {
+		j.transcoded.Add(1)
+	}
+	return errEncode
+}
+
+func (j *JXLHandler) summary() pterm.TableData {
+	return pterm.TableData{
+		{"Jpegs Transcoded Losslessly", strconv.FormatInt(j.transcoded.Load(), 10)},
+	}
+}
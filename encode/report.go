@@ -0,0 +1,344 @@
+package encode
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"DevToolsCLI/file"
+)
+
+var ErrReportFormat = errors.New("--report has to end in .json or .csv")
+
+// ReportRow describes how one source was encoded. Paths are relative to the
+// input and output directories and use forward slashes. Ratio is the output
+// size divided by the input size. Quality is left out for lossless files.
+type ReportRow struct {
+	Input       string   `json:"input"`
+	Outputs     []string `json:"outputs"`
+	Type        string   `json:"type"`
+	InputBytes  int64    `json:"inputBytes"`
+	OutputBytes int64    `json:"outputBytes"`
+	Ratio       float64  `json:"ratio"`
+	DurationMs  float64  `json:"durationMs"`
+	Backend     string   `json:"backend"`
+	Quality     *int     `json:"quality,omitempty"`
+	Settings    string   `json:"settings"`
+	Cached      bool     `json:"cached"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// ReportSummary aggregates the rows that were encoded without errors.
+// Durations of cached rows are left out, DurationMs is their total.
+type ReportSummary struct {
+	Files       int         `json:"files"`
+	Failed      int         `json:"failed"`
+	Cached      int         `json:"cached"`
+	InputBytes  int64       `json:"inputBytes"`
+	OutputBytes int64       `json:"outputBytes"`
+	Ratio       float64     `json:"ratio"`
+	DurationMs  float64     `json:"durationMs"`
+	Percentiles Percentiles `json:"percentiles"`
+}
+
+type Percentiles struct {
+	InputBytes  Distribution `json:"inputBytes"`
+	OutputBytes Distribution `json:"outputBytes"`
+	Ratio       Distribution `json:"ratio"`
+	DurationMs  Distribution `json:"durationMs"`
+}
+
+type Distribution struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// encodeReport collects a row per source, keyed by the absolute input path.
+// The rows keep absolute paths until the report is written.
+type encodeReport struct {
+	path string
+	csv  bool
+	mu   sync.Mutex
+	rows map[string]ReportRow
+}
+
+func newReport(path string) (*encodeReport, error) {
+	absolutePath, errAbs := filepath.Abs(path)
+	if errAbs != nil {
+		return nil, errAbs
+	}
+	r := &encodeReport{path: absolutePath, rows: map[string]ReportRow{}}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+	case ".csv":
+		r.csv = true
+	default:
+		return nil, ErrReportFormat
+	}
+	return r, nil
+}
+
+// reportRow measures the outputs of f after it was encoded as planned by p.
+// p is nil when planning failed.
+func reportRow(f file.InputOutputInfo, p *filePlan, skipped bool, duration time.Duration, errEncode error) ReportRow {
+	row := ReportRow{
+		Input:   f.InputPath,
+		Outputs: []string{f.OutputPath},
+		Type:    string(f.Type),
+		Cached:  skipped,
+	}
+	if p != nil {
+		row.Outputs = p.outputs
+		row.Backend = p.backend
+		row.Quality = p.quality
+		row.Settings = p.settings
+	}
+	if !skipped {
+		row.DurationMs = float64(duration.Microseconds()) / 1000
+	}
+	if info, errStat := os.Stat(f.InputPath); errStat == nil {
+		row.InputBytes = info.Size()
+	}
+	if errEncode != nil {
+		row.Error = errEncode.Error()
+		return row
+	}
+	for _, output := range row.Outputs {
+		info, errStat := os.Stat(output)
+		if errStat != nil {
+			row.Error = errStat.Error()
+			return row
+		}
+		row.OutputBytes += info.Size()
+	}
+	if row.InputBytes > 0 {
+		row.Ratio = ratio(row.OutputBytes, row.InputBytes)
+	}
+	return row
+}
+
+func (r *encodeReport) add(row ReportRow) {
+	r.mu.Lock()
+	r.rows[row.Input] = row
+	r.mu.Unlock()
+}
+
+// remove drops the row of input and, for directories, of everything below it.
+func (r *encodeReport) remove(input string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for path := range r.rows {
+		if isWithin(input, path) {
+			delete(r.rows, path)
+		}
+	}
+}
+
+// rename moves the rows below oldInput to newInput along with their outputs.
+func (r *encodeReport) rename(oldInput, newInput, oldOutput, newOutput string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for path, row := range r.rows {
+		if !isWithin(oldInput, path) {
+			continue
+		}
+		delete(r.rows, path)
+		row.Input = newInput + path[len(oldInput):]
+		outputs := make([]string, 0, len(row.Outputs))
+		for _, output := range row.Outputs {
+			if isWithin(oldOutput, output) {
+				output = newOutput + output[len(oldOutput):]
+			}
+			outputs = append(outputs, output)
+		}
+		row.Outputs = outputs
+		r.rows[row.Input] = row
+	}
+}
+
+// sorted returns the rows sorted by input, with relative paths.
+func (r *encodeReport) sorted(absoluteInputPath, absoluteOutputPath string) []ReportRow {
+	r.mu.Lock()
+	rows := make([]ReportRow, 0, len(r.rows))
+	for _, row := range r.rows {
+		rows = append(rows, row)
+	}
+	r.mu.Unlock()
+	for i := range rows {
+		rows[i].Input = reportPath(rows[i].Input, absoluteInputPath)
+		outputs := make([]string, 0, len(rows[i].Outputs))
+		for _, output := range rows[i].Outputs {
+			outputs = append(outputs, reportPath(output, absoluteOutputPath))
+		}
+		rows[i].Outputs = outputs
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Input < rows[j].Input
+	})
+	return rows
+}
+
+func reportPath(path, root string) string {
+	rel, errRel := filepath.Rel(root, path)
+	if errRel != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func summarize(rows []ReportRow) ReportSummary {
+	summary := ReportSummary{Files: len(rows)}
+	var inputBytes, outputBytes, ratios, durations []float64
+	for _, row := range rows {
+		if row.Error != "" {
+			summary.Failed++
+			continue
+		}
+		if row.Cached {
+			summary.Cached++
+		} else {
+			summary.DurationMs += row.DurationMs
+			durations = append(durations, row.DurationMs)
+		}
+		summary.InputBytes += row.InputBytes
+		summary.OutputBytes += row.OutputBytes
+		inputBytes = append(inputBytes, float64(row.InputBytes))
+		outputBytes = append(outputBytes, float64(row.OutputBytes))
+		ratios = append(ratios, row.Ratio)
+	}
+	if summary.InputBytes > 0 {
+		summary.Ratio = ratio(summary.OutputBytes, summary.InputBytes)
+	}
+	summary.Percentiles = Percentiles{
+		InputBytes:  distribution(inputBytes),
+		OutputBytes: distribution(outputBytes),
+		Ratio:       distribution(ratios),
+		DurationMs:  distribution(durations),
+	}
+	return summary
+}
+
+// distribution uses the nearest rank method, so every percentile is one of
+// the values.
+func distribution(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sort.Float64s(values)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(values)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return values[i]
+	}
+	return Distribution{
+		Count: len(values),
+		Min:   values[0],
+		P50:   rank(50),
+		P90:   rank(90),
+		P95:   rank(95),
+		P99:   rank(99),
+		Max:   values[len(values)-1],
+	}
+}
+
+// write replaces the report. CSV reports put the summary into a second file
+// next to it, see summaryPath.
+func (r *encodeReport) write(absoluteInputPath, absoluteOutputPath string) (ReportSummary, error) {
+	rows := r.sorted(absoluteInputPath, absoluteOutputPath)
+	summary := summarize(rows)
+	if !r.csv {
+		data, errMarshal := json.MarshalIndent(struct {
+			Files   []ReportRow   `json:"files"`
+			Summary ReportSummary `json:"summary"`
+		}{Files: rows, Summary: summary}, "", "  ")
+		if errMarshal != nil {
+			return summary, errMarshal
+		}
+		return summary, os.WriteFile(r.path, append(data, '\n'), 0644)
+	}
+	records := [][]string{{"input", "outputs", "type", "input_bytes", "output_bytes", "ratio", "duration_ms", "backend", "quality", "settings", "cached", "error"}}
+	for _, row := range rows {
+		quality := ""
+		if row.Quality != nil {
+			quality = strconv.Itoa(*row.Quality)
+		}
+		records = append(records, []string{
+			row.Input,
+			strings.Join(row.Outputs, ";"),
+			row.Type,
+			strconv.FormatInt(row.InputBytes, 10),
+			strconv.FormatInt(row.OutputBytes, 10),
+			formatFloat(row.Ratio),
+			formatFloat(row.DurationMs),
+			row.Backend,
+			quality,
+			row.Settings,
+			strconv.FormatBool(row.Cached),
+			row.Error,
+		})
+	}
+	if errWrite := writeCSV(r.path, records); errWrite != nil {
+		return summary, errWrite
+	}
+	counts := func(metric string, count int) []string {
+		return []string{metric, strconv.Itoa(count), "", "", "", "", "", "", ""}
+	}
+	dist := func(metric string, total float64, d Distribution) []string {
+		return []string{metric, strconv.Itoa(d.Count), formatFloat(total), formatFloat(d.Min), formatFloat(d.P50),
+			formatFloat(d.P90), formatFloat(d.P95), formatFloat(d.P99), formatFloat(d.Max)}
+	}
+	p := summary.Percentiles
+	return summary, writeCSV(r.summaryPath(), [][]string{
+		{"metric", "count", "total", "min", "p50", "p90", "p95", "p99", "max"},
+		counts("files", summary.Files),
+		counts("failed", summary.Failed),
+		counts("cached", summary.Cached),
+		dist("input_bytes", float64(summary.InputBytes), p.InputBytes),
+		dist("output_bytes", float64(summary.OutputBytes), p.OutputBytes),
+		dist("ratio", summary.Ratio, p.Ratio),
+		dist("duration_ms", summary.DurationMs, p.DurationMs),
+	})
+}
+
+// summaryPath is report-summary.csv for report.csv.
+func (r *encodeReport) summaryPath() string {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-summary" + ext
+}
+
+func ratio(outputBytes, inputBytes int64) float64 {
+	return math.Round(float64(outputBytes)/float64(inputBytes)*10000) / 10000
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeCSV(path string, records [][]string) error {
+	f, errCreate := os.Create(path)
+	if errCreate != nil {
+		return errCreate
+	}
+	w := csv.NewWriter(f)
+	errWrite := w.WriteAll(records)
+	errClose := f.Close()
+	if errWrite != nil {
+		return errWrite
+	}
+	return errClose
+}
@@ -0,0 +1,190 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitExact   = "exact"
+)
+
+var (
+	ErrUnknownFit     = errors.New("unknown fit mode")
+	ErrInvalidScale   = errors.New("--scale has to be greater than 0 and at most 1")
+	ErrInvalidMaxSize = errors.New("--max-width and --max-height can't be negative")
+	ErrFitNeedsBox    = errors.New("--fit cover and exact need both --max-width and --max-height")
+)
+
+// Resize describes how images are shrunk before they are encoded. Images are
+// never enlarged, except by the exact fit which stretches images that don't
+// fit to the whole box.
+type Resize struct {
+	MaxWidth  int
+	MaxHeight int
+	Scale     float64
+	Fit       string
+}
+
+func NewResize(maxWidth, maxHeight int, scale float64, fit string) (Resize, error) {
+	r := Resize{MaxWidth: maxWidth, MaxHeight: maxHeight, Scale: scale, Fit: fit}
+	if fit != FitContain && fit != FitCover && fit != FitExact {
+		return r, fmt.Errorf("%w: %q, expected %s, %s or %s", ErrUnknownFit, fit, FitContain, FitCover, FitExact)
+	}
+	if scale <= 0 || scale > 1 {
+		return r, ErrInvalidScale
+	}
+	if maxWidth < 0 || maxHeight < 0 {
+		return r, ErrInvalidMaxSize
+	}
+	if fit != FitContain && (maxWidth == 0 || maxHeight == 0) {
+		return r, ErrFitNeedsBox
+	}
+	return r, nil
+}
+
+func (r Resize) enabled() bool {
+	return r.scale() < 1 || r.MaxWidth > 0 || r.MaxHeight > 0
+}
+
+// scale treats the zero value as no scaling.
+func (r Resize) scale() float64 {
+	if r.Scale == 0 {
+		return 1
+	}
+	return r.Scale
+}
+
+func (r Resize) String() string {
+	if !r.enabled() {
+		return "none"
+	}
+	limit := func(v int) string {
+		if v == 0 {
+			return "any"
+		}
+		return fmt.Sprintf("%d", v)
+	}
+	return fmt.Sprintf("scale %g, max %sx%s, fit %s", r.scale(), limit(r.MaxWidth), limit(r.MaxHeight), r.Fit)
+}
+
+// dimensions returns the part of a width x height image that is kept and the
+// size it is scaled to. It returns false when the image is left alone.
+func (r Resize) dimensions(width, height int) (image.Rectangle, int, int, bool) {
+	crop := image.Rect(0, 0, width, height)
+	w, h := float64(width)*r.scale(), float64(height)*r.scale()
+	maxWidth, maxHeight := float64(r.MaxWidth), float64(r.MaxHeight)
+	switch r.Fit {
+	case FitContain:
+		factor := 1.0
+		if maxWidth > 0 && w > maxWidth {
+			factor = math.Min(factor, maxWidth/w)
+		}
+		if maxHeight > 0 && h > maxHeight {
+			factor = math.Min(factor, maxHeight/h)
+		}
+		w, h = w*factor, h*factor
+	case FitCover:
+		if w > maxWidth || h > maxHeight {
+			factor := math.Min(1, math.Max(maxWidth/w, maxHeight/h))
+			w, h = w*factor, h*factor
+			keptWidth, keptHeight := math.Min(w, maxWidth), math.Min(h, maxHeight)
+			cropWidth := int(math.Round(keptWidth / w * float64(width)))
+			cropHeight := int(math.Round(keptHeight / h * float64(height)))
+			crop = image.Rect(0, 0, cropWidth, cropHeight).Add(image.Pt((width-cropWidth)/2, (height-cropHeight)/2))
+			w, h = keptWidth, keptHeight
+		}
+	case FitExact:
+		if w > maxWidth || h > maxHeight {
+			w, h = maxWidth, maxHeight
+		}
+	}
+	targetWidth := int(math.Max(1, math.Round(w)))
+	targetHeight := int(math.Max(1, math.Round(h)))
+	if targetWidth == width && targetHeight == height && crop.Dx() == width && crop.Dy() == height {
+		return crop, width, height, false
+	}
+	return crop, targetWidth, targetHeight, true
+}
+
+// needed reports whether an image of this size would be resized.
+func (r Resize) needed(width, height int) bool {
+	_, _, _, resize := r.dimensions(width, height)
+	return resize
+}
+
+// apply resizes img with a Catmull-Rom filter, images that don't need
+// resizing are returned as they are.
+func (r Resize) apply(img image.Image) image.Image {
+	bounds := img.Bounds()
+	crop, width, height, resize := r.dimensions(bounds.Dx(), bounds.Dy())
+	if !resize {
+		return img
+	}
+	resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, crop.Add(bounds.Min), draw.Src, nil)
+	return resized
+}
+
+// imageSize reads the dimensions of the image at path without decoding it.
+func imageSize(path string) (int, int, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return 0, 0, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	config, _, errConfig := image.DecodeConfig(f)
+	if errConfig != nil {
+		return 0, 0, fmt.Errorf("reading the size of %s: %w", path, errConfig)
+	}
+	return config.Width, config.Height, nil
+}
+
+// resizedCopy writes a resized copy of the image at path to a temporary PNG
+// file for encoders that read files, the caller removes it.
+func resizedCopy(path string, r Resize) (string, error) {
+	img, errDecode := decodeImage(path)
+	if errDecode != nil {
+		return "", errDecode
+	}
+	tmp, errCreate := os.CreateTemp("", "dev-tools-resize-*.png")
+	if errCreate != nil {
+		return "", errCreate
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	errEncode := encoder.Encode(tmp, r.apply(img))
+	errClose := tmp.Close()
+	if errEncode == nil {
+		errEncode = errClose
+	}
+	if errEncode != nil {
+		_ = os.Remove(tmp.Name())
+		return "", errEncode
+	}
+	return tmp.Name(), nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	img, _, errDecode := image.Decode(f)
+	if errDecode != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, errDecode)
+	}
+	return img, nil
+}
@@ -0,0 +1,408 @@
+package encode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/file"
+)
+
+const (
+	defaultManifestName = "manifest.json"
+	defaultHTMLName     = "srcset.html"
+)
+
+var (
+	ErrInvalidWidths = errors.New("--widths has to contain widths greater than 0")
+	ErrSameStem      = errors.New("images with the same name and a different extension would write the same variants")
+)
+
+var subCommandResponsive = &cli.Command{
+	Name:        "responsive",
+	Description: "Encode every image to webp at several widths and write a srcset manifest and html snippets.",
+	Flags: batchFlags(FormatWebP,
+		&cli.IntSliceFlag{
+			Name:     "widths",
+			Required: false,
+			Usage:    "widths in pixels to encode each image at, images are never enlarged",
+			Value:    cli.NewIntSlice(320, 640, 1280, 1920),
+		},
+		&cli.StringFlag{
+			Name:     "lossless",
+			Required: false,
+			Usage:    "enable lossless encoding",
+		},
+		&cli.IntFlag{
+			Name:     "quality",
+			Required: false,
+			Aliases:  []string{"q"},
+			Usage:    "quality 0-100",
+			Value:    80,
+		},
+		&cli.StringFlag{
+			Name:     "backend",
+			Required: false,
+			Usage:    "encoder to use: auto, cwebp, gif2webp or native",
+			Value:    BackendAuto,
+		},
+		&cli.StringFlag{
+			Name:        "manifest",
+			Required:    false,
+			Usage:       "where to write the json manifest",
+			DefaultText: "manifest.json in the output directory",
+		},
+		&cli.BoolFlag{
+			Name:     "html",
+			Required: false,
+			Usage:    "also write an <img> snippet with a srcset for every image to srcset.html in the output directory",
+			Value:    false,
+		},
+		&cli.StringFlag{
+			Name:     "sizes",
+			Required: false,
+			Usage:    "sizes attribute of the html snippets",
+			Value:    "100vw",
+		},
+		&cli.StringFlag{
+			Name:     "base-url",
+			Required: false,
+			Usage:    "prefix for the image paths in the html snippets, e.g. /images/",
+		},
+	),
+	Action: Responsive,
+}
+
+// ManifestImage lists the variants written for one source image. Paths are
+// relative to the output directory and use forward slashes.
+type ManifestImage struct {
+	Source   string            `json:"source"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Variants []ManifestVariant `json:"variants"`
+}
+
+type ManifestVariant struct {
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int64  `json:"size"`
+}
+
+type ResponsiveHandler struct {
+	*WebPHandler
+	Widths       []int
+	ManifestPath string
+	HTMLPath     string
+	Sizes        string
+	BaseURL      string
+
+	mu              sync.Mutex
+	images          []ManifestImage
+	stems           map[string]string
+	variantsWritten atomic.Int64
+}
+
+func Responsive(c *cli.Context) error {
+	backend := c.String("backend")
+	if errBackend := ValidBackend(backend); errBackend != nil {
+		return errBackend
+	}
+	widths, errWidths := responsiveWidths(c.IntSlice("widths"))
+	if errWidths != nil {
+		return errWidths
+	}
+	if errFlags := validEncoderFlags(c, backend, c.IsSet("lossless"), Resize{}); errFlags != nil {
+		return errFlags
+	}
+	batch, errBatch := newBatch(c, FormatWebP)
+	if errBatch != nil {
+		return errBatch
+	}
+	manifestPath := c.String("manifest")
+	if manifestPath == "" {
+		manifestPath = filepath.Join(batch.AbsoluteOutputPath, defaultManifestName)
+	}
+	responsiveHandler := &ResponsiveHandler{
+		WebPHandler: &WebPHandler{
+			Batch:    batch,
+			Lossless: c.IsSet("lossless"),
+			Quality:  c.Int("quality"),
+			KMin:     -1,
+			KMax:     -1,
+			Backend:  backend,
+		},
+		Widths:       widths,
+		ManifestPath: manifestPath,
+		Sizes:        c.String("sizes"),
+		BaseURL:      c.String("base-url"),
+	}
+	if c.Bool("html") {
+		responsiveHandler.HTMLPath = filepath.Join(batch.AbsoluteOutputPath, defaultHTMLName)
+	}
+	return responsiveHandler.Run()
+}
+
+// responsiveWidths sorts the widths and removes duplicates.
+func responsiveWidths(widths []int) ([]int, error) {
+	sorted := append([]int(nil), widths...)
+	sort.Ints(sorted)
+	var unique []int
+	for _, width := range sorted {
+		if width <= 0 {
+			return nil, ErrInvalidWidths
+		}
+		if len(unique) == 0 || unique[len(unique)-1] != width {
+			unique = append(unique, width)
+		}
+	}
+	if len(unique) == 0 {
+		return nil, ErrInvalidWidths
+	}
+	return unique, nil
+}
+
+// variantWidths returns the widths an image of the given width is encoded
+// at. Widths larger than the image are replaced by the width of the image.
+func (r *ResponsiveHandler) variantWidths(width int) []int {
+	var widths []int
+	for _, w := range r.Widths {
+		if w >= width {
+			return append(widths, width)
+		}
+		widths = append(widths, w)
+	}
+	return widths
+}
+
+func (r *ResponsiveHandler) Run() error {
+	return r.Batch.Run(r)
+}
+
+func (r *ResponsiveHandler) settings() pterm.TableData {
+	widths := make([]string, 0, len(r.Widths))
+	for _, width := range r.Widths {
+		widths = append(widths, strconv.Itoa(width))
+	}
+	htmlPath := "none"
+	if r.HTMLPath != "" {
+		htmlPath = r.HTMLPath
+	}
+	return pterm.TableData{
+		{"Animated Gifs Found", strconv.Itoa(len(r.animatedGIFs))},
+		{"Widths", strings.Join(widths, ", ")},
+		{"Lossless Enabled", fmt.Sprintf("%t", r.Lossless)},
+		{"Quality", strconv.Itoa(r.Quality)},
+		{"Backend", backendDescription(r.Backend, r.Lossless)},
+		{"Manifest", r.ManifestPath},
+		{"HTML Snippets", htmlPath},
+	}
+}
+
+// prepare refuses to encode images whose variants would have the same names,
+// like a.png and a.jpg in the same directory.
+func (r *ResponsiveHandler) prepare() error {
+	if errPrepare := r.WebPHandler.prepare(); errPrepare != nil {
+		return errPrepare
+	}
+	r.stems = map[string]string{}
+	var same []string
+	for _, f := range r.InputDirectoryInfo.KnownIOFiles {
+		if !r.enabled(f) {
+			continue
+		}
+		if other, taken := r.stems[f.OutputPath]; taken {
+			same = append(same, r.relativePath(other, r.AbsoluteInputPath)+" and "+r.relativePath(f.InputPath, r.AbsoluteInputPath))
+			continue
+		}
+		r.stems[f.OutputPath] = f.InputPath
+	}
+	if len(same) > 0 {
+		errSame := fmt.Errorf("%w: %s", ErrSameStem, strings.Join(same, ", "))
+		log.Error().Err(errSame).Msg("Error planning variants")
+		return errSame
+	}
+	return nil
+}
+
+// claimStem reserves the variant names of f, images added while watching
+// can't take the names of another image that still exists.
+func (r *ResponsiveHandler) claimStem(f file.InputOutputInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	other, taken := r.stems[f.OutputPath]
+	if taken && other != f.InputPath {
+		if _, errStat := os.Stat(other); errStat == nil {
+			return fmt.Errorf("%w: %s and %s", ErrSameStem, r.relativePath(other, r.AbsoluteInputPath), r.relativePath(f.InputPath, r.AbsoluteInputPath))
+		}
+	}
+	r.stems[f.OutputPath] = f.InputPath
+	return nil
+}
+
+// plan measures f once and picks the encoder of every variant, resizing gifs
+// needs the native encoder.
+func (r *ResponsiveHandler) plan(f file.InputOutputInfo) (*filePlan, error) {
+	if errStem := r.claimStem(f); errStem != nil {
+		return nil, errStem
+	}
+	job, errJob := r.job(f)
+	if errJob != nil {
+		return nil, errJob
+	}
+	width, height, errSize := imageSize(f.InputPath)
+	if errSize != nil {
+		return nil, errSize
+	}
+	p := &filePlan{InputOutputInfo: f, width: width, height: height}
+	base := strings.TrimSuffix(f.OutputPath, r.Format.Extension)
+	var keys, backends []string
+	for _, variantWidth := range r.variantWidths(width) {
+		resize := Resize{MaxWidth: variantWidth, Scale: 1, Fit: FitContain}
+		_, w, h, _ := resize.dimensions(width, height)
+		variant := job
+		variant.OutputPath = fmt.Sprintf("%s-%dw%s", base, w, r.Format.Extension)
+		step, errStep := r.step(variant, resize, width, height)
+		if errStep != nil {
+			return nil, errStep
+		}
+		step.width, step.height = w, h
+		p.steps = append(p.steps, step)
+		p.outputs = append(p.outputs, variant.OutputPath)
+		keys = append(keys, r.stepKey(step))
+		if len(backends) == 0 || backends[len(backends)-1] != step.encoder.Name() {
+			backends = append(backends, step.encoder.Name())
+		}
+		if p.quality == nil {
+			p.quality = r.fixedQuality(step)
+		}
+	}
+	p.settings = "responsive " + strings.Join(keys, "; ")
+	p.backend = strings.Join(backends, ", ")
+	return p, nil
+}
+
+func (r *ResponsiveHandler) encodeFile(p *filePlan) error {
+	for _, step := range p.steps {
+		errEncode := r.encode(p, step)
+		if errEncode != nil {
+			return errEncode
+		}
+		r.variantsWritten.Add(1)
+	}
+	return r.record(p)
+}
+
+// skipFile still adds the up to date variants to the manifest.
+func (r *ResponsiveHandler) skipFile(p *filePlan) error {
+	return r.record(p)
+}
+
+func (r *ResponsiveHandler) record(p *filePlan) error {
+	entry := ManifestImage{Source: r.relativePath(p.InputPath, r.AbsoluteInputPath), Width: p.width, Height: p.height}
+	for _, step := range p.steps {
+		info, errStat := os.Stat(step.job.OutputPath)
+		if errStat != nil {
+			return errStat
+		}
+		entry.Variants = append(entry.Variants, ManifestVariant{
+			Path:   r.relativePath(step.job.OutputPath, r.AbsoluteOutputPath),
+			Width:  step.width,
+			Height: step.height,
+			Size:   info.Size(),
+		})
+	}
+	r.mu.Lock()
+	r.images = append(r.images, entry)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *ResponsiveHandler) relativePath(path, root string) string {
+	rel, errRel := filepath.Rel(root, path)
+	if errRel != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// finish writes the manifest and the html snippets, sorted by source so
+// reruns produce the same files.
+func (r *ResponsiveHandler) finish() error {
+	sort.Slice(r.images, func(i, j int) bool {
+		return r.images[i].Source < r.images[j].Source
+	})
+	manifest, errMarshal := json.MarshalIndent(struct {
+		Images []ManifestImage `json:"images"`
+	}{Images: r.images}, "", "  ")
+	if errMarshal != nil {
+		return errMarshal
+	}
+	errWrite := os.WriteFile(r.ManifestPath, append(manifest, '\n'), 0644)
+	if errWrite != nil {
+		log.Error().Err(errWrite).Msg("Error writing manifest")
+		return errWrite
+	}
+	if r.HTMLPath == "" {
+		return nil
+	}
+	errWrite = os.WriteFile(r.HTMLPath, []byte(r.snippets()), 0644)
+	if errWrite != nil {
+		log.Error().Err(errWrite).Msg("Error writing html snippets")
+	}
+	return errWrite
+}
+
+// snippets returns an <img> with a srcset for every image. Only the webp
+// variants are in the output directory, so there is no <picture> with a
+// fallback, src is the largest variant.
+func (r *ResponsiveHandler) snippets() string {
+	var b strings.Builder
+	for _, img := range r.images {
+		if len(img.Variants) == 0 {
+			continue
+		}
+		srcset := make([]string, 0, len(img.Variants))
+		for _, v := range img.Variants {
+			srcset = append(srcset, fmt.Sprintf("%s %dw", r.url(v.Path), v.Width))
+		}
+		largest := img.Variants[len(img.Variants)-1]
+		fmt.Fprintf(&b, "<!-- %s -->\n", html.EscapeString(img.Source))
+		fmt.Fprintf(&b, "<img src=\"%s\" srcset=\"%s\" sizes=\"%s\" width=\"%d\" height=\"%d\" alt=\"\" loading=\"lazy\" decoding=\"async\">\n\n",
+			html.EscapeString(r.url(largest.Path)), html.EscapeString(strings.Join(srcset, ", ")), html.EscapeString(r.Sizes),
+			largest.Width, largest.Height)
+	}
+	return b.String()
+}
+
+// url escapes path for srcset, which separates candidates with spaces and
+// commas.
+func (r *ResponsiveHandler) url(path string) string {
+	escaped := (&url.URL{Path: path}).EscapedPath()
+	return r.BaseURL + strings.ReplaceAll(escaped, ",", "%2C")
+}
+
+func (r *ResponsiveHandler) summary() pterm.TableData {
+	rows := pterm.TableData{
+		{"Images", strconv.Itoa(len(r.images))},
+		{"Variants Written", strconv.FormatInt(r.variantsWritten.Load(), 10)},
+		{"Manifest", r.ManifestPath},
+	}
+	if r.HTMLPath != "" {
+		rows = append(rows, []string{"HTML Snippets", r.HTMLPath})
+	}
+	return rows
+}
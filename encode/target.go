@@ -0,0 +1,231 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrTargetConflict    = errors.New("--target-size and --target-ssim can't be combined")
+	ErrTargetLossless    = errors.New("--target-size and --target-ssim pick a lossy quality, they can't be combined with --lossless")
+	ErrTargetNeedsLossy  = errors.New("--target-size and --target-ssim need cwebp or gif2webp, the native encoder only writes lossless files")
+	ErrInvalidTargetSize = errors.New("--target-size has to be a size like 150KB")
+	ErrInvalidTargetSSIM = errors.New("--target-ssim has to be between 0 and 1")
+	ErrSSIMSize          = errors.New("the encoded image has a different size than its source")
+)
+
+// Target makes the quality a per image search instead of a setting. Size is
+// the largest output in bytes, SSIM the lowest similarity to the source.
+// Zero values disable them.
+type Target struct {
+	Size int64
+	SSIM float64
+}
+
+func NewTarget(size string, ssim float64) (Target, error) {
+	var t Target
+	if size != "" {
+		bytes, errParse := humanize.ParseBytes(size)
+		if errParse != nil || bytes == 0 {
+			return t, fmt.Errorf("%w: %q", ErrInvalidTargetSize, size)
+		}
+		t.Size = int64(bytes)
+	}
+	if ssim < 0 || ssim > 1 {
+		return t, ErrInvalidTargetSSIM
+	}
+	t.SSIM = ssim
+	if t.Size > 0 && t.SSIM > 0 {
+		return t, ErrTargetConflict
+	}
+	return t, nil
+}
+
+func (t Target) enabled() bool {
+	return t.Size > 0 || t.SSIM > 0
+}
+
+func (t Target) String() string {
+	switch {
+	case t.Size > 0:
+		return fmt.Sprintf("at most %s", humanize.Bytes(uint64(t.Size)))
+	case t.SSIM > 0:
+		return fmt.Sprintf("ssim of at least %g", t.SSIM)
+	}
+	return "none"
+}
+
+// appliesTo reports whether the quality of j can be searched. Only cwebp and
+// gif2webp take a quality, and animations can't be decoded to compare them.
+func (t Target) appliesTo(j Job, encoder Encoder) bool {
+	if !t.enabled() {
+		return false
+	}
+	switch encoder.Name() {
+	case BackendCWebP:
+		return true
+	case BackendGif2WebP:
+		return t.SSIM == 0 || !j.Animated
+	}
+	return false
+}
+
+// searchQuality binary searches the quality of j: the highest quality that
+// stays within the target size or the lowest that reaches the target ssim.
+// When no quality reaches the target the closest one is kept. Every trial
+// is written next to the output and the chosen one is renamed to it.
+func (t Target) searchQuality(j Job, encoder Encoder, opts EncodeOptions) (int, error) {
+	if j.Resize {
+		// Resize once instead of for every trial.
+		resized, errResize := resizedCopy(j.InputPath, opts.Resize)
+		if errResize != nil {
+			return 0, errResize
+		}
+		defer func() {
+			_ = os.Remove(resized)
+		}()
+		j.InputPath = resized
+		j.Resize = false
+	}
+	var reference *image.Gray
+	if t.SSIM > 0 {
+		img, errDecode := decodeImage(j.InputPath)
+		if errDecode != nil {
+			return 0, errDecode
+		}
+		reference = grayscale(img)
+	}
+
+	trials := map[int]string{}
+	defer func() {
+		for _, trial := range trials {
+			_ = os.Remove(trial)
+		}
+	}()
+	meets := func(quality int) (bool, error) {
+		tmp, errCreate := os.CreateTemp(filepath.Dir(j.OutputPath), ".dev-tools-target-*"+filepath.Ext(j.OutputPath))
+		if errCreate != nil {
+			return false, errCreate
+		}
+		_ = tmp.Close()
+		trials[quality] = tmp.Name()
+		trial := j
+		trial.OutputPath = tmp.Name()
+		opts.Quality = quality
+		if errEncode := encoder.Encode(trial, opts); errEncode != nil {
+			return false, errEncode
+		}
+		if t.Size > 0 {
+			info, errStat := os.Stat(tmp.Name())
+			if errStat != nil {
+				return false, errStat
+			}
+			return info.Size() <= t.Size, nil
+		}
+		encoded, errDecode := decodeImage(tmp.Name())
+		if errDecode != nil {
+			return false, errDecode
+		}
+		similarity, errSSIM := ssim(reference, grayscale(encoded))
+		return similarity >= t.SSIM, errSSIM
+	}
+
+	// Higher qualities make larger and more similar files.
+	chosen, fallback := -1, 0
+	if t.SSIM > 0 {
+		fallback = 100
+	}
+	low, high := 0, 100
+	for low <= high {
+		quality := (low + high) / 2
+		ok, errTrial := meets(quality)
+		if errTrial != nil {
+			return 0, errTrial
+		}
+		switch {
+		case ok && t.Size > 0:
+			chosen, low = quality, quality+1
+		case ok:
+			chosen, high = quality, quality-1
+		case t.Size > 0:
+			high = quality - 1
+		default:
+			low = quality + 1
+		}
+	}
+	if chosen < 0 {
+		chosen = fallback
+	}
+	if errRename := os.Rename(trials[chosen], j.OutputPath); errRename != nil {
+		return 0, errRename
+	}
+	delete(trials, chosen)
+	return chosen, nil
+}
+
+func grayscale(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	return gray
+}
+
+// ssim is the mean structural similarity of the luma of two images, over 8x8
+// windows that overlap by half.
+func ssim(a, b *image.Gray) (float64, error) {
+	if a.Bounds() != b.Bounds() {
+		return 0, fmt.Errorf("%w: %v and %v", ErrSSIMSize, a.Bounds().Size(), b.Bounds().Size())
+	}
+	const (
+		window = 8
+		step   = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+	windowWidth, windowHeight := window, window
+	if width < windowWidth {
+		windowWidth = width
+	}
+	if height < windowHeight {
+		windowHeight = height
+	}
+	var total float64
+	var windows int
+	for y := 0; y+windowHeight <= height; y += step {
+		for x := 0; x+windowWidth <= width; x += step {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := y; wy < y+windowHeight; wy++ {
+				rowA := a.Pix[wy*a.Stride+x : wy*a.Stride+x+windowWidth]
+				rowB := b.Pix[wy*b.Stride+x : wy*b.Stride+x+windowWidth]
+				for i := range rowA {
+					pa, pb := float64(rowA[i]), float64(rowB[i])
+					sumA += pa
+					sumB += pb
+					sumAA += pa * pa
+					sumBB += pb * pb
+					sumAB += pa * pb
+				}
+			}
+			n := float64(windowWidth * windowHeight)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			covariance := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*covariance + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	if windows == 0 {
+		return 1, nil
+	}
+	return total / float64(windows), nil
+}
@@ -0,0 +1,758 @@
+package encode
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// This file implements a lossless WebP (VP8L) encoder as described in
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+// It uses the subtract green and predictor transforms, LZ77 backward
+// references and one set of prefix codes for the whole image. It compresses
+// worse and slower than libwebp but needs nothing outside of the standard
+// library.
+
+const (
+	vp8lSignature        = 0x2f
+	vp8lMaxDimension     = 1 << 14
+	vp8lMaxCodeLength    = 15
+	vp8lMaxCLCodeLength  = 7
+	vp8lNumLiteralCodes  = 256
+	vp8lNumLengthCodes   = 24
+	vp8lNumDistanceCodes = 40
+	vp8lPredictorBits    = 4
+	vp8lMinMatch         = 3
+	vp8lMaxMatch         = 4096
+	vp8lWindow           = 1 << 18
+	vp8lHashBits         = 16
+	vp8lMaxChain         = 24
+
+	transformPredictor     = 0
+	transformSubtractGreen = 2
+)
+
+var ErrImageTooLarge = errors.New("image is larger than the 16384x16384 pixels webp supports")
+
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeLossless writes img as a lossless WebP file.
+func encodeLossless(w io.Writer, img image.Image) error {
+	data, _, errEncode := vp8lBitstream(img)
+	if errEncode != nil {
+		return errEncode
+	}
+	return writeWebPContainer(w, "VP8L", data)
+}
+
+// vp8lBitstream returns the contents of the VP8L chunk for img and whether
+// the image has any transparent pixels.
+func vp8lBitstream(img image.Image) ([]byte, bool, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return nil, false, ErrImageTooLarge
+	}
+	argb, hasAlpha := imageToARGB(img)
+
+	bw := &bitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if hasAlpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3)
+
+	subtractGreen(argb)
+	bw.writeBits(1, 1)
+	bw.writeBits(transformSubtractGreen, 2)
+
+	modes, modesWidth := predict(argb, width, height)
+	bw.writeBits(1, 1)
+	bw.writeBits(transformPredictor, 2)
+	bw.writeBits(vp8lPredictorBits-2, 3)
+	writeEntropyImage(bw, modes, modesWidth, false)
+
+	bw.writeBits(0, 1)
+	writeEntropyImage(bw, argb, width, true)
+
+	return bw.bytes(), hasAlpha, nil
+}
+
+// writeWebPContainer wraps a single VP8 or VP8L chunk in a RIFF container.
+func writeWebPContainer(w io.Writer, fourCC string, data []byte) error {
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+	buffered := bufio.NewWriter(w)
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+padded))
+	copy(header[8:], "WEBP")
+	copy(header[12:], fourCC)
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+	if _, errWrite := buffered.Write(header); errWrite != nil {
+		return errWrite
+	}
+	if _, errWrite := buffered.Write(data); errWrite != nil {
+		return errWrite
+	}
+	if padded != chunkSize {
+		if errWrite := buffered.WriteByte(0); errWrite != nil {
+			return errWrite
+		}
+	}
+	return buffered.Flush()
+}
+
+func imageToARGB(img image.Image) ([]uint32, bool) {
+	bounds := img.Bounds()
+	argb := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	hasAlpha := false
+	nrgba, isNRGBA := img.(*image.NRGBA)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// WebP stores colors without premultiplied alpha, converting from
+			// premultiplied colors would lose precision at low alpha.
+			var c color.NRGBA
+			if isNRGBA {
+				c = nrgba.NRGBAAt(x, y)
+			} else {
+				c = color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			}
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+			argb = append(argb, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+	return argb, hasAlpha
+}
+
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		green := (p >> 8) & 0xff
+		red := ((p >> 16) - green) & 0xff
+		blue := (p - green) & 0xff
+		argb[i] = p&0xff00ff00 | red<<16 | blue
+	}
+}
+
+// predictorModes are the modes tried for every block, the others rarely win.
+var predictorModes = []int{1, 2, 3, 4, 7, 11, 12, 13}
+
+// predict replaces every pixel with the residual of the predictor mode that
+// works best for its block and returns the modes as a sub image.
+func predict(argb []uint32, width, height int) ([]uint32, int) {
+	blockSize := 1 << vp8lPredictorBits
+	modesWidth := (width + blockSize - 1) / blockSize
+	modesHeight := (height + blockSize - 1) / blockSize
+	modes := make([]uint32, modesWidth*modesHeight)
+	original := make([]uint32, len(argb))
+	copy(original, argb)
+
+	for by := 0; by < modesHeight; by++ {
+		for bx := 0; bx < modesWidth; bx++ {
+			best, bestCost := 0, -1
+			for _, mode := range predictorModes {
+				cost := 0
+				for y := by * blockSize; y < height && y < (by+1)*blockSize; y++ {
+					for x := bx * blockSize; x < width && x < (bx+1)*blockSize; x++ {
+						cost += residualCost(original[y*width+x], predictPixel(original, width, x, y, mode))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*modesWidth+bx] = 0xff000000 | uint32(best)<<8
+		}
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := int(modes[(y>>vp8lPredictorBits)*modesWidth+x>>vp8lPredictorBits]>>8) & 0xf
+			argb[y*width+x] = subPixels(original[y*width+x], predictPixel(original, width, x, y, mode))
+		}
+	}
+	return modes, modesWidth
+}
+
+func residualCost(pixel, predicted uint32) int {
+	residual := subPixels(pixel, predicted)
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int((residual >> shift) & 0xff)
+		if v > 128 {
+			v = 256 - v
+		}
+		cost += v
+	}
+	return cost
+}
+
+func predictPixel(argb []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[i-1]
+	case x == 0:
+		return argb[i-width]
+	}
+	left, top, topLeft, topRight := argb[i-1], argb[i-width], argb[i-width-1], argb[i-width+1]
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	case 11:
+		return selectPixel(left, top, topLeft)
+	case 12:
+		return clampAddSubtractFull(left, top, topLeft)
+	default:
+		return clampAddSubtractHalf(average2(left, top), topLeft)
+	}
+}
+
+func channel(p uint32, shift int) int {
+	return int((p >> shift) & 0xff)
+}
+
+func subPixels(a, b uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		result |= uint32((channel(a, shift)-channel(b, shift))&0xff) << shift
+	}
+	return result
+}
+
+func average2(a, b uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		result |= uint32((channel(a, shift)+channel(b, shift))/2) << shift
+	}
+	return result
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clamp255(v int) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint32(v)
+}
+
+func selectPixel(left, top, topLeft uint32) uint32 {
+	distanceLeft, distanceTop := 0, 0
+	for shift := 0; shift < 32; shift += 8 {
+		estimate := channel(left, shift) + channel(top, shift) - channel(topLeft, shift)
+		distanceLeft += abs(estimate - channel(left, shift))
+		distanceTop += abs(estimate - channel(top, shift))
+	}
+	if distanceLeft < distanceTop {
+		return left
+	}
+	return top
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		result |= clamp255(channel(a, shift)+channel(b, shift)-channel(c, shift)) << shift
+	}
+	return result
+}
+
+func clampAddSubtractHalf(a, b uint32) uint32 {
+	var result uint32
+	for shift := 0; shift < 32; shift += 8 {
+		result |= clamp255(channel(a, shift)+(channel(a, shift)-channel(b, shift))/2) << shift
+	}
+	return result
+}
+
+// token is either a literal pixel or, when length is set, a backward
+// reference of length pixels distance pixels back.
+type token struct {
+	argb     uint32
+	length   uint32
+	distance uint32
+}
+
+// backwardReferences finds repeated runs of pixels with a hash chain over
+// three pixels at a time.
+func backwardReferences(argb []uint32, width int) []token {
+	tokens := make([]token, 0, len(argb)/2)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(argb))
+	hashAt := func(i int) uint32 {
+		h := argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1 ^ argb[i+2]*0x85ebca6b
+		return h >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+2 >= len(argb) {
+			return
+		}
+		h := hashAt(i)
+		prev[i] = head[h]
+		head[h] = int32(i)
+	}
+	for i := 0; i < len(argb); {
+		bestLength, bestDistance := 0, 0
+		if i+vp8lMinMatch <= len(argb) {
+			candidate := head[hashAt(i)]
+			for chain := 0; candidate >= 0 && chain < vp8lMaxChain && i-int(candidate) <= vp8lWindow; chain++ {
+				c := int(candidate)
+				length := 0
+				for length < vp8lMaxMatch && i+length < len(argb) && argb[c+length] == argb[i+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength, bestDistance = length, i-c
+					if length == vp8lMaxMatch {
+						break
+					}
+				}
+				candidate = prev[c]
+			}
+		}
+		if bestLength >= vp8lMinMatch {
+			tokens = append(tokens, token{length: uint32(bestLength), distance: distanceCode(bestDistance, width)})
+			for j := i; j < i+bestLength; j++ {
+				insert(j)
+			}
+			i += bestLength
+			continue
+		}
+		tokens = append(tokens, token{argb: argb[i]})
+		insert(i)
+		i++
+	}
+	return tokens
+}
+
+// distanceCode maps a distance to its code, using the short codes for the
+// pixel above and the pixel to the left.
+func distanceCode(distance, width int) uint32 {
+	switch distance {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return uint32(distance + 120)
+}
+
+// prefixEncode splits a length or distance code into a prefix symbol and
+// extra bits.
+func prefixEncode(value uint32) (symbol int, extraBits int, extra uint32) {
+	value--
+	if value < 4 {
+		return int(value), 0, 0
+	}
+	highest := 31
+	for value>>highest == 0 {
+		highest--
+	}
+	second := (value >> (highest - 1)) & 1
+	extraBits = highest - 1
+	return 2*highest + int(second), extraBits, value & (1<<extraBits - 1)
+}
+
+// writeEntropyImage writes pixels with backward references and one set of
+// five prefix codes. The main image has an extra bit for meta prefix codes,
+// sub images used by transforms don't.
+func writeEntropyImage(bw *bitWriter, argb []uint32, width int, isMain bool) {
+	tokens := backwardReferences(argb, width)
+	histograms := [5][]int{
+		make([]int, vp8lNumLiteralCodes+vp8lNumLengthCodes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, vp8lNumDistanceCodes),
+	}
+	for _, t := range tokens {
+		if t.length > 0 {
+			symbol, _, _ := prefixEncode(t.length)
+			histograms[0][vp8lNumLiteralCodes+symbol]++
+			symbol, _, _ = prefixEncode(t.distance)
+			histograms[4][symbol]++
+			continue
+		}
+		histograms[0][(t.argb>>8)&0xff]++
+		histograms[1][(t.argb>>16)&0xff]++
+		histograms[2][t.argb&0xff]++
+		histograms[3][t.argb>>24]++
+	}
+
+	bw.writeBits(0, 1)
+	if isMain {
+		bw.writeBits(0, 1)
+	}
+	var codes [5]prefixCode
+	for i, histogram := range histograms {
+		codes[i] = writePrefixCode(bw, histogram)
+	}
+	for _, t := range tokens {
+		if t.length > 0 {
+			symbol, extraBits, extra := prefixEncode(t.length)
+			codes[0].write(bw, vp8lNumLiteralCodes+symbol)
+			bw.writeBits(extra, extraBits)
+			symbol, extraBits, extra = prefixEncode(t.distance)
+			codes[4].write(bw, symbol)
+			bw.writeBits(extra, extraBits)
+			continue
+		}
+		codes[0].write(bw, int((t.argb>>8)&0xff))
+		codes[1].write(bw, int((t.argb>>16)&0xff))
+		codes[2].write(bw, int(t.argb&0xff))
+		codes[3].write(bw, int(t.argb>>24))
+	}
+}
+
+// prefixCode holds canonical codes, already bit reversed for the LSB first
+// bit writer. A code with a single symbol takes no bits at all.
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (p prefixCode) write(bw *bitWriter, symbol int) {
+	bw.writeBits(p.codes[symbol], p.lengths[symbol])
+}
+
+// writePrefixCode writes the code for histogram and returns it. Alphabets
+// with up to two symbols below 256 use the simple code, everything else the
+// normal code lengths.
+func writePrefixCode(bw *bitWriter, histogram []int) prefixCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	code := prefixCode{lengths: make([]int, len(histogram)), codes: make([]uint32, len(histogram))}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		bw.writeBits(1, 1)
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.writeBits(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.writeBits(0, 1)
+			bw.writeBits(uint32(used[0]), 1)
+		} else {
+			bw.writeBits(1, 1)
+			bw.writeBits(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.writeBits(uint32(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	if len(used) == 1 {
+		// A single symbol above 255 can't use the simple code, a second
+		// symbol with a one bit code keeps the normal code complete.
+		dummy := 0
+		if used[0] == 0 {
+			dummy = 1
+		}
+		histogram = append([]int(nil), histogram...)
+		histogram[dummy] = 1
+	}
+	code.lengths = huffmanLengths(histogram, vp8lMaxCodeLength)
+	code.codes = canonicalCodes(code.lengths)
+
+	bw.writeBits(0, 1)
+	symbols, extras := runLengthCodeLengths(code.lengths)
+	clHistogram := make([]int, 19)
+	for _, s := range symbols {
+		clHistogram[s]++
+	}
+	clLengths := huffmanLengths(clHistogram, vp8lMaxCLCodeLength)
+	if countNonZero(clLengths) == 1 {
+		// The code length code needs at least two symbols as well.
+		for i := range clLengths {
+			if clLengths[i] == 0 {
+				clLengths[i] = 1
+				break
+			}
+		}
+		for i := range clLengths {
+			if clLengths[i] != 0 {
+				clLengths[i] = 1
+			}
+		}
+	}
+	clCodes := canonicalCodes(clLengths)
+	count := 19
+	for count > 4 && clLengths[codeLengthCodeOrder[count-1]] == 0 {
+		count--
+	}
+	bw.writeBits(uint32(count-4), 4)
+	for i := 0; i < count; i++ {
+		bw.writeBits(uint32(clLengths[codeLengthCodeOrder[i]]), 3)
+	}
+	bw.writeBits(0, 1)
+	for i, s := range symbols {
+		bw.writeBits(clCodes[s], clLengths[s])
+		switch s {
+		case 16:
+			bw.writeBits(extras[i], 2)
+		case 17:
+			bw.writeBits(extras[i], 3)
+		case 18:
+			bw.writeBits(extras[i], 7)
+		}
+	}
+	return code
+}
+
+func countNonZero(values []int) int {
+	n := 0
+	for _, v := range values {
+		if v != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// runLengthCodeLengths encodes code lengths with the repeat codes 16, 17 and
+// 18. The previous length for code 16 starts at 8 and only changes on
+// non-zero lengths.
+func runLengthCodeLengths(lengths []int) ([]int, []uint32) {
+	var symbols []int
+	var extras []uint32
+	previous := 8
+	for i := 0; i < len(lengths); {
+		length := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == length {
+			run++
+		}
+		if length == 0 {
+			for run >= 3 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				if n >= 11 {
+					symbols, extras = append(symbols, 18), append(extras, uint32(n-11))
+				} else {
+					symbols, extras = append(symbols, 17), append(extras, uint32(n-3))
+				}
+				i += n
+				run -= n
+			}
+			for ; run > 0; run-- {
+				symbols, extras = append(symbols, 0), append(extras, 0)
+				i++
+			}
+			continue
+		}
+		if length != previous {
+			symbols, extras = append(symbols, length), append(extras, 0)
+			previous = length
+			i++
+			run--
+		}
+		for run >= 3 {
+			n := run
+			if n > 6 {
+				n = 6
+			}
+			symbols, extras = append(symbols, 16), append(extras, uint32(n-3))
+			i += n
+			run -= n
+		}
+		for ; run > 0; run-- {
+			symbols, extras = append(symbols, length), append(extras, 0)
+			i++
+		}
+	}
+	return symbols, extras
+}
+
+type huffmanNode struct {
+	count  int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type huffmanHeap []*huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].count == h[j].count {
+		return h[i].symbol < h[j].symbol
+	}
+	return h[i].count < h[j].count
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// huffmanLengths returns code lengths of at most maxLength bits. When the
+// optimal code is too deep the counts are flattened and it is built again.
+func huffmanLengths(histogram []int, maxLength int) []int {
+	counts := append([]int(nil), histogram...)
+	for {
+		lengths := make([]int, len(counts))
+		h := &huffmanHeap{}
+		for symbol, count := range counts {
+			if count > 0 {
+				*h = append(*h, &huffmanNode{count: count, symbol: symbol})
+			}
+		}
+		if h.Len() == 0 {
+			return lengths
+		}
+		if h.Len() == 1 {
+			lengths[(*h)[0].symbol] = 1
+			return lengths
+		}
+		heap.Init(h)
+		for h.Len() > 1 {
+			a := heap.Pop(h).(*huffmanNode)
+			b := heap.Pop(h).(*huffmanNode)
+			symbol := a.symbol
+			if b.symbol < symbol {
+				symbol = b.symbol
+			}
+			heap.Push(h, &huffmanNode{count: a.count + b.count, symbol: symbol, left: a, right: b})
+		}
+		tooLong := false
+		var walk func(n *huffmanNode, depth int)
+		walk = func(n *huffmanNode, depth int) {
+			if n.left == nil {
+				lengths[n.symbol] = depth
+				if depth > maxLength {
+					tooLong = true
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk((*h)[0], 0)
+		if !tooLong {
+			return lengths
+		}
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = count>>1 | 1
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns codes in order of length and then symbol, like
+// DEFLATE, and reverses them since the bit writer is LSB first.
+func canonicalCodes(lengths []int) []uint32 {
+	codes := make([]uint32, len(lengths))
+	symbols := make([]int, 0, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.SliceStable(symbols, func(a, b int) bool {
+		return lengths[symbols[a]] < lengths[symbols[b]]
+	})
+	code, previousLength := uint32(0), 0
+	for _, symbol := range symbols {
+		length := lengths[symbol]
+		code <<= length - previousLength
+		previousLength = length
+		codes[symbol] = reverseBits(code, length)
+		code++
+	}
+	return codes
+}
+
+func reverseBits(code uint32, length int) uint32 {
+	var reversed uint32
+	for i := 0; i < length; i++ {
+		reversed = reversed<<1 | code&1
+		code >>= 1
+	}
+	return reversed
+}
+
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits int
+}
+
+func (b *bitWriter) writeBits(value uint32, n int) {
+	if n == 0 {
+		return
+	}
+	b.acc |= uint64(value&(1<<n-1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nbits = 0, 0
+	}
+	return b.buf
+}
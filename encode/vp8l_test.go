@@ -0,0 +1,219 @@
+package encode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testImage returns an image with bounds r whose pixels mix gradients, noise
+// and repeated runs, with transparency when alpha is set.
+func testImage(r image.Rectangle, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	seed := uint32(1)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			seed = seed*1664525 + 1013904223
+			c := color.NRGBA{R: uint8(x * 7), G: uint8(y * 13), B: uint8(seed >> 24), A: 0xff}
+			if (x/4+y/3)%3 == 0 {
+				c = color.NRGBA{R: 0x20, G: 0x40, B: 0x60, A: 0xff}
+			}
+			if alpha {
+				c.A = uint8(x*y + x)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeLosslessRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "1x1", img: testImage(image.Rect(0, 0, 1, 1), false)},
+		{name: "1x1 transparent", img: image.NewNRGBA(image.Rect(0, 0, 1, 1))},
+		{name: "odd size", img: testImage(image.Rect(0, 0, 17, 9), false)},
+		{name: "single column", img: testImage(image.Rect(0, 0, 1, 33), false)},
+		{name: "larger than a predictor block", img: testImage(image.Rect(0, 0, 131, 67), false)},
+		{name: "sub image", img: testImage(image.Rect(0, 0, 40, 30), false).SubImage(image.Rect(5, 7, 28, 26))},
+		{name: "non zero min", img: testImage(image.Rect(-3, 11, 20, 24), false)},
+		{name: "alpha", img: testImage(image.Rect(0, 0, 29, 21), true)},
+		{name: "paletted", img: image.NewPaletted(image.Rect(0, 0, 9, 5), color.Palette{color.Black, color.White})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if errEncode := encodeLossless(&buf, tt.img); errEncode != nil {
+				t.Fatal(errEncode)
+			}
+			decoded, errDecode := webp.Decode(&buf)
+			if errDecode != nil {
+				t.Fatal(errDecode)
+			}
+			bounds := tt.img.Bounds()
+			if decoded.Bounds().Dx() != bounds.Dx() || decoded.Bounds().Dy() != bounds.Dy() {
+				t.Fatalf("decoded size = %v, want %v", decoded.Bounds().Size(), bounds.Size())
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					if want.A == 0 {
+						want = color.NRGBA{}
+					}
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeLosslessTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if errEncode := encodeLossless(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 5))); errEncode != ErrImageTooLarge {
+		t.Errorf("encodeLossless() error = %v, want %v", errEncode, ErrImageTooLarge)
+	}
+}
+
+type webpChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// parseChunks splits a RIFF WebP file into its chunks and checks the RIFF
+// size.
+func parseChunks(t *testing.T, data []byte) []webpChunk {
+	t.Helper()
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		t.Fatalf("missing RIFF WEBP header")
+	}
+	if riffSize := int(binary.LittleEndian.Uint32(data[4:])); riffSize != len(data)-8 {
+		t.Fatalf("RIFF size = %d, want %d", riffSize, len(data)-8)
+	}
+	return splitChunks(t, data[12:])
+}
+
+func splitChunks(t *testing.T, data []byte) []webpChunk {
+	t.Helper()
+	var chunks []webpChunk
+	for rest := data; len(rest) > 0; {
+		if len(rest) < 8 {
+			t.Fatalf("truncated chunk header")
+		}
+		size := int(binary.LittleEndian.Uint32(rest[4:]))
+		if len(rest) < chunkLength(size) {
+			t.Fatalf("%s chunk of %d bytes is truncated", rest[0:4], size)
+		}
+		chunks = append(chunks, webpChunk{fourCC: string(rest[0:4]), data: rest[8 : 8+size]})
+		rest = rest[chunkLength(size):]
+	}
+	return chunks
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func TestEncodeAnimatedLossless(t *testing.T) {
+	palette := color.Palette{color.Black, color.NRGBA{R: 0xff, A: 0xff}, color.NRGBA{G: 0xff, A: 0xff}}
+	filled := func(r image.Rectangle, index uint8) *image.Paletted {
+		frame := image.NewPaletted(r, palette)
+		for i := range frame.Pix {
+			frame.Pix[i] = index
+		}
+		return frame
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			filled(image.Rect(0, 0, 11, 7), 0),
+			filled(image.Rect(5, 3, 8, 5), 1),
+			filled(image.Rect(5, 3, 8, 5), 1),
+			filled(image.Rect(1, 1, 2, 2), 2),
+		},
+		Delay:     []int{10, 20, 30, 40},
+		LoopCount: 2,
+		Config:    image.Config{Width: 11, Height: 7},
+	}
+	type frame struct {
+		X, Y, Width, Height, Duration int
+	}
+	tests := []struct {
+		name    string
+		minSize bool
+		want    []frame
+	}{
+		{
+			name: "full frames",
+			want: []frame{{0, 0, 11, 7, 100}, {0, 0, 11, 7, 200}, {0, 0, 11, 7, 300}, {0, 0, 11, 7, 400}},
+		},
+		{
+			name:    "min size",
+			minSize: true,
+			want:    []frame{{0, 0, 11, 7, 100}, {4, 2, 4, 3, 500}, {0, 0, 2, 2, 400}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if errEncode := encodeAnimatedLossless(&buf, g, tt.minSize, Resize{}); errEncode != nil {
+				t.Fatal(errEncode)
+			}
+			chunks := parseChunks(t, buf.Bytes())
+			if len(chunks) < 2 || chunks[0].fourCC != "VP8X" || chunks[1].fourCC != "ANIM" {
+				t.Fatalf("file doesn't start with VP8X and ANIM chunks")
+			}
+			vp8x := chunks[0].data
+			if vp8x[0]&vp8xFlagAnimation == 0 {
+				t.Errorf("VP8X animation flag isn't set")
+			}
+			if width, height := uint24(vp8x[4:])+1, uint24(vp8x[7:])+1; width != 11 || height != 7 {
+				t.Errorf("canvas = %dx%d, want 11x7", width, height)
+			}
+			if loopCount := binary.LittleEndian.Uint16(chunks[1].data[4:]); loopCount != 3 {
+				t.Errorf("loop count = %d, want 3", loopCount)
+			}
+			var got []frame
+			for _, chunk := range chunks[2:] {
+				if chunk.fourCC != "ANMF" {
+					t.Fatalf("unexpected %s chunk", chunk.fourCC)
+				}
+				f := frame{
+					X:        uint24(chunk.data[0:]) * 2,
+					Y:        uint24(chunk.data[3:]) * 2,
+					Width:    uint24(chunk.data[6:]) + 1,
+					Height:   uint24(chunk.data[9:]) + 1,
+					Duration: uint24(chunk.data[12:]),
+				}
+				got = append(got, f)
+				frameChunks := splitChunks(t, chunk.data[16:])
+				if len(frameChunks) != 1 || frameChunks[0].fourCC != "VP8L" {
+					t.Fatalf("ANMF chunk doesn't hold a single VP8L chunk")
+				}
+				var single bytes.Buffer
+				if errWrite := writeWebPContainer(&single, "VP8L", frameChunks[0].data); errWrite != nil {
+					t.Fatal(errWrite)
+				}
+				decoded, errDecode := webp.Decode(&single)
+				if errDecode != nil {
+					t.Fatal(errDecode)
+				}
+				if size := decoded.Bounds().Size(); size.X != f.Width || size.Y != f.Height {
+					t.Errorf("frame bitstream is %v, want %dx%d", size, f.Width, f.Height)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("frames = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package encode
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+
+	"DevToolsCLI/file"
+)
+
+const defaultDebounce = 500 * time.Millisecond
+
+var (
+	ErrWatchOverflow = errors.New("too many changes at once, some were missed")
+	ErrWatchStopped  = errors.New("the watcher stopped unexpectedly")
+)
+
+type watchOp int
+
+const (
+	watchWrite watchOp = iota
+	watchRemove
+	watchRename
+)
+
+// watchEvent is a change below the watched directory. OldPath is only set for
+// renames.
+type watchEvent struct {
+	Op      watchOp
+	Path    string
+	OldPath string
+	IsDir   bool
+}
+
+// treeWatcher reports changes to every file below a directory. There is an
+// inotify implementation for linux and a polling one for everything else.
+// Events is closed when the watcher fails, after sending the error.
+type treeWatcher interface {
+	Events() <-chan watchEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// watch keeps the output directory in sync with the input directory until it
+// is interrupted. Writes are collected until a file hasn't changed for the
+// debounce duration, deletes and renames are mirrored right away.
+func (b *Batch) watch(h formatHandler) error {
+	watcher, errWatcher := newTreeWatcher(b.AbsoluteInputPath)
+	if errWatcher != nil {
+		log.Error().Err(errWatcher).Msg("Error watching the input directory")
+		return errWatcher
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	tick := b.Debounce / 2
+	if tick < 20*time.Millisecond {
+		tick = 20 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	pterm.Println()
+	pterm.Info.Printfln("Watching %s for changes, press Ctrl+C to stop.", b.AbsoluteInputPath)
+	pending := map[string]time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			pterm.Info.Println("Stopped watching.")
+			return nil
+		case errWatch := <-watcher.Errors():
+			log.Error().Err(errWatch).Msg("Error watching the input directory")
+		case event, ok := <-watcher.Events():
+			if !ok {
+				errWatch := watcherError(watcher)
+				log.Error().Err(errWatch).Msg("Error watching the input directory")
+				return errWatch
+			}
+			if isWithin(b.AbsoluteOutputPath, event.Path) {
+				continue
+			}
+			switch event.Op {
+			case watchWrite:
+				pending[event.Path] = time.Now()
+			case watchRemove:
+				forgetPending(pending, event.Path)
+				b.removeOutput(event)
+			case watchRename:
+				movePending(pending, event.OldPath, event.Path)
+				b.renameOutput(event, pending)
+			}
+		case now := <-ticker.C:
+			b.flush(h, pending, now)
+		}
+	}
+}
+
+// watcherError returns the last error a stopped watcher sent.
+func watcherError(watcher treeWatcher) error {
+	errWatch := ErrWatchStopped
+	for {
+		select {
+		case errSent := <-watcher.Errors():
+			errWatch = errSent
+		default:
+			return errWatch
+		}
+	}
+}
+
+// flush encodes the files that haven't changed for the debounce duration.
+func (b *Batch) flush(h formatHandler, pending map[string]time.Time, now time.Time) {
+	var ready []string
+	for path, changed := range pending {
+		if now.Sub(changed) >= b.Debounce {
+			ready = append(ready, path)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	sort.Strings(ready)
+	for _, path := range ready {
+		delete(pending, path)
+		b.encodeWatched(h, path)
+	}
+	if b.cache != nil {
+		if errSave := b.cache.save(); errSave != nil {
+			log.Error().Err(errSave).Msg("Error saving the encode cache")
+		}
+	}
+	_, _ = b.writeReport()
+}
+
+func (b *Batch) encodeWatched(h formatHandler, path string) {
+	f, isImage, errInfo := file.GetInputOutputInfo(b.AbsoluteInputPath, b.AbsoluteOutputPath, path, b.Format.Extension, b.Filter)
+	if errors.Is(errInfo, fs.ErrNotExist) {
+		return
+	}
+	if errInfo != nil {
+		log.Error().Err(errInfo).Str("path", path).Msg("Error reading changed file")
+		return
+	}
+	if !isImage || !b.enabled(f) {
+		return
+	}
+	if errMkdir := os.MkdirAll(filepath.Dir(f.OutputPath), 0o755); errMkdir != nil {
+		log.Error().Err(errMkdir).Msg("Error creating output sub directory")
+		return
+	}
+	skipped, errEncode := b.encodeFile(h, f)
+	if errEncode != nil {
+		log.Error().Err(errEncode).Str("path", path).Msg("Error encoding file")
+		return
+	}
+	if skipped {
+		pterm.Info.Printfln("%s is up to date", b.relativeInput(path))
+		return
+	}
+	pterm.Success.Printfln("Encoded %s", b.relativeInput(path))
+}
+
+func (b *Batch) outputFor(path string, isDir bool) string {
+	return file.GetTrunkedOutputPath(b.AbsoluteInputPath, b.AbsoluteOutputPath, path, b.Format.Extension, isDir)
+}
+
+// removeOutput deletes the outputs a deleted file or the files below a
+// deleted directory were encoded to in this run, then the directories that
+// are left empty. Other files in the output directory are kept, like the
+// output of a source with the same stem.
+func (b *Batch) removeOutput(event watchEvent) {
+	outputs := b.written.remove(event.Path)
+	if len(outputs) == 0 {
+		return
+	}
+	for _, output := range outputs {
+		errRemove := os.Remove(output)
+		if errRemove != nil && !errors.Is(errRemove, fs.ErrNotExist) {
+			log.Error().Err(errRemove).Str("path", output).Msg("Error removing output")
+			return
+		}
+	}
+	if event.IsDir {
+		removeEmptyDirectories(b.outputFor(event.Path, true), b.AbsoluteOutputPath)
+	}
+	if b.report != nil {
+		b.report.remove(event.Path)
+		_, _ = b.writeReport()
+	}
+	pterm.Info.Printfln("Removed %s", b.relativeInput(event.Path))
+}
+
+// removeEmptyDirectories removes dir and the directories below it that are
+// empty, but never root.
+func removeEmptyDirectories(dir, root string) {
+	if dir == root {
+		return
+	}
+	var dirs []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, errWalk error) error {
+		if errWalk == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+}
+
+// renameOutput moves the output along with its source. Files that are no
+// longer encoded after the rename lose their outputs, files that weren't
+// encoded before or whose outputs can't simply be moved are encoded again.
+func (b *Batch) renameOutput(event watchEvent, pending map[string]time.Time) {
+	oldOutput := b.outputFor(event.OldPath, event.IsDir)
+	if event.IsDir {
+		if _, errStat := os.Stat(oldOutput); errStat != nil || oldOutput == b.AbsoluteOutputPath {
+			return
+		}
+		b.moveOutput(event, oldOutput, b.outputFor(event.Path, true))
+		return
+	}
+	f, isImage, errInfo := file.GetInputOutputInfo(b.AbsoluteInputPath, b.AbsoluteOutputPath, event.Path, b.Format.Extension, b.Filter)
+	encoded := errInfo == nil && isImage && b.enabled(f)
+	outputs := b.written.owned(event.OldPath)
+	_, waiting := pending[event.Path]
+	switch {
+	case encoded && len(outputs) == 1 && outputs[0] == oldOutput && !waiting:
+		b.moveOutput(event, oldOutput, f.OutputPath)
+	case encoded:
+		b.removeOutput(watchEvent{Op: watchRemove, Path: event.OldPath})
+		pending[event.Path] = time.Now()
+	default:
+		b.removeOutput(watchEvent{Op: watchRemove, Path: event.OldPath})
+	}
+}
+
+func (b *Batch) moveOutput(event watchEvent, oldOutput, newOutput string) {
+	if oldOutput == newOutput {
+		return
+	}
+	if errMkdir := os.MkdirAll(filepath.Dir(newOutput), 0o755); errMkdir != nil {
+		log.Error().Err(errMkdir).Msg("Error creating output sub directory")
+		return
+	}
+	if errRename := os.Rename(oldOutput, newOutput); errRename != nil {
+		log.Error().Err(errRename).Str("path", oldOutput).Msg("Error renaming output")
+		return
+	}
+	b.written.rename(event.OldPath, event.Path, oldOutput, newOutput)
+	if b.report != nil {
+		b.report.rename(event.OldPath, event.Path, oldOutput, newOutput)
+		_, _ = b.writeReport()
+	}
+	pterm.Info.Printfln("Renamed %s to %s", b.relativeInput(event.OldPath), b.relativeInput(event.Path))
+}
+
+// writtenOutputs remembers the outputs each source was encoded to, keyed by
+// the absolute input path, so deletes and renames only touch files the batch
+// wrote.
+type writtenOutputs struct {
+	mu      sync.Mutex
+	sources map[string][]string
+}
+
+func (w *writtenOutputs) add(input string, outputs []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sources == nil {
+		w.sources = map[string][]string{}
+	}
+	w.sources[input] = outputs
+}
+
+// owned returns the outputs of input that no other source was encoded to.
+func (w *writtenOutputs) owned(input string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.unclaimed(func(path string) bool {
+		return path == input
+	})
+}
+
+// remove forgets the sources below input and returns their outputs, except
+// for the ones another source was encoded to as well.
+func (w *writtenOutputs) remove(input string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	removed := w.unclaimed(func(path string) bool {
+		return isWithin(input, path)
+	})
+	for path := range w.sources {
+		if isWithin(input, path) {
+			delete(w.sources, path)
+		}
+	}
+	return removed
+}
+
+// unclaimed returns the outputs of the sources matching selected that none of
+// the other sources were encoded to.
+func (w *writtenOutputs) unclaimed(selected func(path string) bool) []string {
+	claimed := map[string]bool{}
+	for path, outputs := range w.sources {
+		if selected(path) {
+			continue
+		}
+		for _, output := range outputs {
+			claimed[output] = true
+		}
+	}
+	var result []string
+	for path, outputs := range w.sources {
+		if !selected(path) {
+			continue
+		}
+		for _, output := range outputs {
+			if !claimed[output] {
+				result = append(result, output)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// rename moves the sources below oldInput to newInput along with their
+// outputs.
+func (w *writtenOutputs) rename(oldInput, newInput, oldOutput, newOutput string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, outputs := range w.sources {
+		if !isWithin(oldInput, path) {
+			continue
+		}
+		delete(w.sources, path)
+		moved := make([]string, 0, len(outputs))
+		for _, output := range outputs {
+			if isWithin(oldOutput, output) {
+				output = newOutput + output[len(oldOutput):]
+			}
+			moved = append(moved, output)
+		}
+		w.sources[newInput+path[len(oldInput):]] = moved
+	}
+}
+
+func (b *Batch) relativeInput(path string) string {
+	rel, errRel := filepath.Rel(b.AbsoluteInputPath, path)
+	if errRel != nil {
+		return path
+	}
+	return rel
+}
+
+func isWithin(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// forgetPending drops queued writes of path and, for directories, of
+// everything below it.
+func forgetPending(pending map[string]time.Time, path string) {
+	for queued := range pending {
+		if isWithin(path, queued) {
+			delete(pending, queued)
+		}
+	}
+}
+
+func movePending(pending map[string]time.Time, oldPath, newPath string) {
+	for queued, changed := range pending {
+		if isWithin(oldPath, queued) {
+			delete(pending, queued)
+			pending[newPath+queued[len(oldPath):]] = changed
+		}
+	}
+}
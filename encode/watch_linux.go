@@ -0,0 +1,198 @@
+//go:build linux
+
+package encode
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// inotifyWatcher watches every directory of a tree with inotify. Directories
+// that are created or moved in are added as they appear.
+type inotifyWatcher struct {
+	fd     int
+	file   *os.File
+	dirs   map[int]string
+	events chan watchEvent
+	errors chan error
+}
+
+func newTreeWatcher(root string) (treeWatcher, error) {
+	fd, errInit := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if errInit != nil {
+		return nil, errInit
+	}
+	w := &inotifyWatcher{
+		fd: fd,
+		// Reading through os.File uses the runtime poller, so Close
+		// unblocks the read loop.
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   map[int]string{},
+		events: make(chan watchEvent, 1024),
+		errors: make(chan error, 16),
+	}
+	if _, errAdd := w.addTree(root, false); errAdd != nil {
+		_ = w.file.Close()
+		return nil, errAdd
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan watchEvent {
+	return w.events
+}
+
+func (w *inotifyWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// addTree watches dir and its subdirectories. With existing set it also
+// returns write events for the files already in them, which is needed for
+// directories that are moved in or filled before the watch was added.
+func (w *inotifyWatcher) addTree(dir string, existing bool) ([]watchEvent, error) {
+	var found []watchEvent
+	errWalk := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			if existing {
+				found = append(found, watchEvent{Op: watchWrite, Path: path})
+			}
+			return nil
+		}
+		wd, errAdd := unix.InotifyAddWatch(w.fd, path, inotifyMask|unix.IN_ONLYDIR)
+		if errors.Is(errAdd, unix.ENOENT) {
+			return filepath.SkipDir
+		}
+		if errAdd != nil {
+			return &fs.PathError{Op: "inotify_add_watch", Path: path, Err: errAdd}
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+	return found, errWalk
+}
+
+// removeTree forgets the watches below dir after it was moved out of the
+// tree. The kernel keeps watching it, but the events would have wrong paths.
+func (w *inotifyWatcher) removeTree(dir string) {
+	for wd, path := range w.dirs {
+		if isWithin(dir, path) {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+func (w *inotifyWatcher) renameTree(oldDir, newDir string) {
+	for wd, path := range w.dirs {
+		if isWithin(oldDir, path) {
+			w.dirs[wd] = newDir + strings.TrimPrefix(path, oldDir)
+		}
+	}
+}
+
+func (w *inotifyWatcher) run() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, errRead := w.file.Read(buf)
+		if errRead != nil {
+			if !errors.Is(errRead, os.ErrClosed) {
+				w.errors <- errRead
+			}
+			return
+		}
+		for _, event := range w.parse(buf[:n]) {
+			w.events <- event
+		}
+	}
+}
+
+// parse turns a batch of raw inotify events into watch events. A rename
+// within the tree arrives as a MOVED_FROM and a MOVED_TO with the same cookie,
+// a MOVED_FROM without its MOVED_TO left the tree and is a removal.
+func (w *inotifyWatcher) parse(buf []byte) []watchEvent {
+	var events []watchEvent
+	movedFrom := map[uint32]int{}
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(raw.Len)]
+		offset += unix.SizeofInotifyEvent + int(raw.Len)
+		name := strings.TrimRight(string(nameBytes), "\x00")
+
+		if raw.Mask&unix.IN_Q_OVERFLOW != 0 {
+			w.errors <- ErrWatchOverflow
+			continue
+		}
+		if raw.Mask&unix.IN_IGNORED != 0 {
+			delete(w.dirs, int(raw.Wd))
+			continue
+		}
+		dir, known := w.dirs[int(raw.Wd)]
+		if !known || name == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		isDir := raw.Mask&unix.IN_ISDIR != 0
+		switch {
+		case raw.Mask&unix.IN_MOVED_FROM != 0:
+			movedFrom[raw.Cookie] = len(events)
+			events = append(events, watchEvent{Op: watchRemove, Path: path, IsDir: isDir})
+		case raw.Mask&unix.IN_MOVED_TO != 0:
+			if i, paired := movedFrom[raw.Cookie]; paired {
+				delete(movedFrom, raw.Cookie)
+				events[i] = watchEvent{Op: watchRename, Path: path, OldPath: events[i].Path, IsDir: isDir}
+				if isDir {
+					w.renameTree(events[i].OldPath, path)
+				}
+				continue
+			}
+			events = append(events, w.appeared(path, isDir)...)
+		case raw.Mask&unix.IN_CREATE != 0:
+			if isDir {
+				events = append(events, w.appeared(path, true)...)
+			}
+		case raw.Mask&unix.IN_CLOSE_WRITE != 0:
+			events = append(events, watchEvent{Op: watchWrite, Path: path})
+		case raw.Mask&unix.IN_DELETE != 0:
+			events = append(events, watchEvent{Op: watchRemove, Path: path, IsDir: isDir})
+		}
+	}
+	for _, i := range movedFrom {
+		if events[i].IsDir {
+			w.removeTree(events[i].Path)
+		}
+	}
+	return events
+}
+
+// appeared handles a file or directory that was created or moved into the
+// tree.
+func (w *inotifyWatcher) appeared(path string, isDir bool) []watchEvent {
+	if !isDir {
+		return []watchEvent{{Op: watchWrite, Path: path}}
+	}
+	found, errAdd := w.addTree(path, true)
+	if errAdd != nil {
+		w.errors <- errAdd
+	}
+	return found
+}
@@ -0,0 +1,104 @@
+//go:build !linux
+
+package encode
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// pollingWatcher compares snapshots of the tree. It can't tell renames from
+// a removal and a new file, so renamed images are encoded again.
+type pollingWatcher struct {
+	root   string
+	files  map[string]fileState
+	done   chan struct{}
+	events chan watchEvent
+	errors chan error
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func newTreeWatcher(root string) (treeWatcher, error) {
+	files, errSnapshot := snapshot(root)
+	if errSnapshot != nil {
+		return nil, errSnapshot
+	}
+	w := &pollingWatcher{
+		root:   root,
+		files:  files,
+		done:   make(chan struct{}),
+		events: make(chan watchEvent, 1024),
+		errors: make(chan error, 16),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *pollingWatcher) Events() <-chan watchEvent {
+	return w.events
+}
+
+func (w *pollingWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *pollingWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+func (w *pollingWatcher) run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		files, errSnapshot := snapshot(w.root)
+		if errSnapshot != nil {
+			w.errors <- errSnapshot
+			continue
+		}
+		for path, state := range w.files {
+			if _, exists := files[path]; !exists {
+				w.events <- watchEvent{Op: watchRemove, Path: path, IsDir: state.isDir}
+			}
+		}
+		for path, state := range files {
+			old, existed := w.files[path]
+			if !state.isDir && (!existed || old.size != state.size || !old.modTime.Equal(state.modTime)) {
+				w.events <- watchEvent{Op: watchWrite, Path: path}
+			}
+		}
+		w.files = files
+	}
+}
+
+func snapshot(root string) (map[string]fileState, error) {
+	files := map[string]fileState{}
+	errWalk := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		info, errInfo := d.Info()
+		if errInfo != nil {
+			return errInfo
+		}
+		files[path] = fileState{size: info.Size(), modTime: info.ModTime(), isDir: d.IsDir()}
+		return nil
+	})
+	return files, errWalk
+}
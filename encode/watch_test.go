@@ -0,0 +1,142 @@
+package encode
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"DevToolsCLI/file"
+)
+
+// copyHandler writes the input path to the output instead of encoding it.
+type copyHandler struct{}
+
+func (copyHandler) prepare() error            { return nil }
+func (copyHandler) settings() pterm.TableData { return nil }
+func (copyHandler) finish() error             { return nil }
+func (copyHandler) summary() pterm.TableData  { return nil }
+func (copyHandler) skipFile(*filePlan) error  { return nil }
+
+func (copyHandler) plan(f file.InputOutputInfo) (*filePlan, error) {
+	return &filePlan{InputOutputInfo: f, outputs: []string{f.OutputPath}}, nil
+}
+
+func (copyHandler) encodeFile(p *filePlan) error {
+	return os.WriteFile(p.OutputPath, []byte(p.InputPath), 0o644)
+}
+
+func writePNG(t *testing.T, path string) {
+	t.Helper()
+	out, errCreate := os.Create(path)
+	if errCreate != nil {
+		t.Fatal(errCreate)
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+	if errEncode := png.Encode(out, image.NewGray(image.Rect(0, 0, 1, 1))); errEncode != nil {
+		t.Fatal(errEncode)
+	}
+}
+
+func TestWatchRemovesOnlyWrittenOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   watchEvent
+		removed []string
+		kept    []string
+	}{
+		{
+			name:  "deleted file that wasn't encoded",
+			event: watchEvent{Op: watchRemove, Path: "a.txt"},
+			kept:  []string{"a.webp", "b.webp", "sub/c.webp", "sub/notes.txt"},
+		},
+		{
+			name:    "deleted image",
+			event:   watchEvent{Op: watchRemove, Path: "a.png"},
+			removed: []string{"a.webp"},
+			kept:    []string{"b.webp", "sub/c.webp"},
+		},
+		{
+			name:  "deleted image sharing its output",
+			event: watchEvent{Op: watchRemove, Path: "b.jpg"},
+			kept:  []string{"a.webp", "b.webp"},
+		},
+		{
+			name:    "deleted directory",
+			event:   watchEvent{Op: watchRemove, Path: "sub", IsDir: true},
+			removed: []string{"sub/c.webp"},
+			kept:    []string{"a.webp", "sub/notes.txt"},
+		},
+		{
+			name:  "renamed file that wasn't encoded",
+			event: watchEvent{Op: watchRename, OldPath: "a.txt", Path: "d.txt"},
+			kept:  []string{"a.webp", "b.webp"},
+		},
+		{
+			name:    "renamed image",
+			event:   watchEvent{Op: watchRename, OldPath: "a.png", Path: "e.png"},
+			removed: []string{"a.webp"},
+			kept:    []string{"e.webp", "b.webp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, output := t.TempDir(), t.TempDir()
+			b := &Batch{Format: FormatWebP, AbsoluteInputPath: input, AbsoluteOutputPath: output, PngsEnabled: true, JpegsEnabled: true}
+			if errMkdir := os.MkdirAll(filepath.Join(input, "sub"), 0o755); errMkdir != nil {
+				t.Fatal(errMkdir)
+			}
+			if errMkdir := os.MkdirAll(filepath.Join(output, "sub"), 0o755); errMkdir != nil {
+				t.Fatal(errMkdir)
+			}
+			for _, source := range []string{"a.png", "b.png", "b.jpg", "sub/c.png"} {
+				path := filepath.Join(input, filepath.FromSlash(source))
+				writePNG(t, path)
+				f := file.InputOutputInfo{InputPath: path, OutputPath: b.outputFor(path, false), Type: file.TypePng}
+				if _, errEncode := b.encodeFile(copyHandler{}, f); errEncode != nil {
+					t.Fatal(errEncode)
+				}
+			}
+			for _, other := range []string{"a.txt", "sub/notes.txt"} {
+				if errWrite := os.WriteFile(filepath.Join(input, filepath.FromSlash(other)), []byte(other), 0o644); errWrite != nil {
+					t.Fatal(errWrite)
+				}
+			}
+			if errWrite := os.WriteFile(filepath.Join(output, "sub", "notes.txt"), []byte("notes"), 0o644); errWrite != nil {
+				t.Fatal(errWrite)
+			}
+
+			event := tt.event
+			event.Path = filepath.Join(input, filepath.FromSlash(event.Path))
+			if event.Op == watchRename {
+				event.OldPath = filepath.Join(input, filepath.FromSlash(event.OldPath))
+				if errRename := os.Rename(event.OldPath, event.Path); errRename != nil {
+					t.Fatal(errRename)
+				}
+				b.renameOutput(event, map[string]time.Time{})
+			} else {
+				if errRemove := os.RemoveAll(event.Path); errRemove != nil {
+					t.Fatal(errRemove)
+				}
+				b.removeOutput(event)
+			}
+
+			for _, removed := range tt.removed {
+				if _, errStat := os.Stat(filepath.Join(output, filepath.FromSlash(removed))); !os.IsNotExist(errStat) {
+					t.Errorf("%s wasn't removed", removed)
+				}
+			}
+			for _, kept := range tt.kept {
+				if _, errStat := os.Stat(filepath.Join(output, filepath.FromSlash(kept))); errStat != nil {
+					t.Errorf("%s was removed", kept)
+				}
+			}
+		})
+	}
+}
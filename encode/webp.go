@@ -1,37 +1,21 @@
 package encode
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"runtime"
 	"strconv"
-	"time"
+	"sync/atomic"
 
-	"github.com/dustin/go-humanize"
 	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 
-	"golang.org/x/sync/errgroup"
-
 	"DevToolsCLI/file"
 )
 
 var subCommandWebP = &cli.Command{
 	Name: "webp",
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:        "input",
-			Required:    true,
-			DefaultText: "the directory you want to encode the webp files from",
-		},
-		&cli.StringFlag{
-			Name:     "output",
-			Required: true,
-			Usage:    "the directory you want to output the webp files to",
-		},
+	Flags: batchFlags(FormatWebP,
 		&cli.StringFlag{
 			Name:     "lossless",
 			Required: false,
@@ -45,230 +29,397 @@ var subCommandWebP = &cli.Command{
 			Value:    80,
 		},
 		&cli.BoolFlag{
-			Name:     "jpegs",
+			Name:     "mixed",
 			Required: false,
-			Usage:    "encode jpegs",
+			Usage:    "let gif2webp pick lossy or lossless compression for each frame of an animated gif",
 			Value:    false,
 		},
 		&cli.BoolFlag{
-			Name:     "pngs",
+			Name:     "min-size",
 			Required: false,
-			Usage:    "encode pngs",
+			Usage:    "make animations as small as possible, slower; the native encoder only stores the changed area of each frame",
 			Value:    false,
 		},
+		&cli.IntFlag{
+			Name:        "kmin",
+			Required:    false,
+			Usage:       "minimum distance between key frames of animations encoded with gif2webp",
+			Value:       -1,
+			DefaultText: "gif2webp's default",
+		},
+		&cli.IntFlag{
+			Name:        "kmax",
+			Required:    false,
+			Usage:       "maximum distance between key frames of animations encoded with gif2webp, 0 disables key frames",
+			Value:       -1,
+			DefaultText: "gif2webp's default",
+		},
+		&cli.IntFlag{
+			Name:     "max-width",
+			Required: false,
+			Usage:    "shrink images wider than this many pixels, 0 has no limit",
+			Value:    0,
+		},
+		&cli.IntFlag{
+			Name:     "max-height",
+			Required: false,
+			Usage:    "shrink images higher than this many pixels, 0 has no limit",
+			Value:    0,
+		},
+		&cli.Float64Flag{
+			Name:     "scale",
+			Required: false,
+			Usage:    "scale every image by this factor before applying the limits, between 0 and 1",
+			Value:    1,
+		},
+		&cli.StringFlag{
+			Name:     "fit",
+			Required: false,
+			Usage:    "how images larger than --max-width and --max-height are fitted: contain keeps the whole image, cover crops it to fill the box, exact stretches it to the box",
+			Value:    FitContain,
+		},
+		&cli.StringFlag{
+			Name:     "target-size",
+			Required: false,
+			Usage:    "search the highest quality for each image that stays within this size, e.g. 150KB, instead of using --quality",
+		},
+		&cli.Float64Flag{
+			Name:     "target-ssim",
+			Required: false,
+			Usage:    "search the lowest quality for each image that reaches this ssim between 0 and 1, e.g. 0.98, instead of using --quality",
+			Value:    0,
+		},
+		&cli.StringFlag{
+			Name:     "backend",
+			Required: false,
+			Usage:    "encoder to use: auto, cwebp, gif2webp or native, auto picks cwebp and gif2webp when installed and the built in lossless encoder otherwise",
+			Value:    BackendAuto,
+		},
 		&cli.BoolFlag{
-			Name:     "gifs",
+			Name:     "watch",
 			Required: false,
-			Usage:    "encode gifs",
+			Usage:    "keep watching the input directory after encoding and encode new or changed images, deleted and renamed images are deleted and renamed in the output directory",
 			Value:    false,
 		},
-	},
+		&cli.DurationFlag{
+			Name:     "debounce",
+			Required: false,
+			Usage:    "how long a file has to stay unchanged before it is encoded in watch mode",
+			Value:    defaultDebounce,
+		},
+	),
 	Action: WebP,
 }
 
+var (
+	ErrAnimationNeedsGif2WebP = errors.New("--mixed, --kmin and --kmax only apply to gif2webp, animated gifs are encoded with the native encoder")
+	ErrQualityNeedsLossy      = errors.New("--quality needs cwebp or gif2webp, the native encoder only writes lossless files")
+)
+
 type WebPHandler struct {
-	InputDirectoryInfo  file.DirectoryInfo
-	OutputDirectoryInfo file.DirectoryInfo
-	AbsoluteInputPath   string
-	AbsoluteOutputPath  string
-	JpegsEnabled        bool
-	PngsEnabled         bool
-	GifsEnabled         bool
-	Lossless            bool
-	Quality             int
+	*Batch
+	Lossless bool
+	Quality  int
+	Mixed    bool
+	MinSize  bool
+	KMin     int
+	KMax     int
+	Backend  string
+	Resize   Resize
+	Target   Target
+
+	animatedGIFs      map[string]bool
+	animatedConverted atomic.Int64
+	resized           atomic.Int64
 }
 
 func WebP(c *cli.Context) error {
-	inputDirectory := c.String("input")
-	outputDirectory := c.String("output")
-	quality := c.Int("quality")
 	lossless := c.IsSet("lossless")
-	jpegs := c.Bool("jpegs")
-	pngs := c.Bool("pngs")
-	gifs := c.Bool("gifs")
-	if !c.IsSet("jpegs") && !c.IsSet("pngs") && !c.IsSet("gifs") {
-		jpegs = true
-		pngs = true
-		gifs = true
-	}
-	absoluteInputPath, err := filepath.Abs(inputDirectory)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting absolute path of input directory")
-		return err
-	}
-	absoluteOutputPath, err := filepath.Abs(outputDirectory)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting absolute path of output directory")
-		return err
+	backend := c.String("backend")
+	if errBackend := ValidBackend(backend); errBackend != nil {
+		return errBackend
 	}
-
+	if c.Bool("mixed") && lossless {
+		return ErrMixedLossless
+	}
+	resize, errResize := NewResize(c.Int("max-width"), c.Int("max-height"), c.Float64("scale"), c.String("fit"))
+	if errResize != nil {
+		return errResize
+	}
+	target, errTarget := NewTarget(c.String("target-size"), c.Float64("target-ssim"))
+	if errTarget != nil {
+		return errTarget
+	}
+	if errTarget = validTarget(target, lossless, backend); errTarget != nil {
+		return errTarget
+	}
+	if errFlags := validEncoderFlags(c, backend, lossless, resize); errFlags != nil {
+		return errFlags
+	}
+	batch, errBatch := newBatch(c, FormatWebP)
+	if errBatch != nil {
+		return errBatch
+	}
+	batch.Watch = c.Bool("watch")
+	batch.Debounce = c.Duration("debounce")
 	wpHandler := &WebPHandler{
-		AbsoluteInputPath:  absoluteInputPath,
-		AbsoluteOutputPath: absoluteOutputPath,
-		JpegsEnabled:       jpegs,
-		PngsEnabled:        pngs,
-		GifsEnabled:        gifs,
-		Lossless:           lossless,
-		Quality:            quality,
+		Batch:    batch,
+		Lossless: lossless,
+		Quality:  c.Int("quality"),
+		Mixed:    c.Bool("mixed"),
+		MinSize:  c.Bool("min-size"),
+		KMin:     c.Int("kmin"),
+		KMax:     c.Int("kmax"),
+		Backend:  backend,
+		Resize:   resize,
+		Target:   target,
 	}
+	return wpHandler.Run()
+}
 
-	inputDirectoryInfo, err := file.GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, absoluteInputPath)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting input directory info")
-		return err
-	}
-	outputDirectoryInfo, err := file.GetDirectoryInfoIO(absoluteOutputPath, absoluteOutputPath, absoluteOutputPath)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting output directory info")
-		return err
-	}
-	wpHandler.InputDirectoryInfo = inputDirectoryInfo
-	wpHandler.OutputDirectoryInfo = outputDirectoryInfo
+func (w *WebPHandler) Run() error {
+	return w.Batch.Run(w)
+}
 
-	errOutput := wpHandler.createOutputDirectoriesFromInputSubDirectories()
-	if errOutput != nil {
-		log.Error().Err(errOutput).Msg("Error creating output directories")
-		return errOutput
+func (w *WebPHandler) prepare() error {
+	w.skipLossyWithoutCWebP()
+	if !w.GifsEnabled {
+		return nil
 	}
+	errAnimated := w.findAnimatedGIFs()
+	if errAnimated != nil {
+		log.Error().Err(errAnimated).Msg("Error checking gifs for animations")
+	}
+	return errAnimated
+}
 
-	return wpHandler.Run()
+func (w *WebPHandler) settings() pterm.TableData {
+	return pterm.TableData{
+		{"Animated Gifs Found", strconv.Itoa(len(w.animatedGIFs))},
+		{"Lossless Enabled", fmt.Sprintf("%t", w.Lossless)},
+		{"Quality", strconv.Itoa(w.Quality)},
+		{"Animation Settings", w.animationDescription()},
+		{"Resize", w.Resize.String()},
+		{"Target", w.Target.String()},
+		{"Backend", backendDescription(w.Backend, w.Lossless)},
+	}
 }
 
-func (w *WebPHandler) createOutputDirectoriesFromInputSubDirectories() error {
-	for _, subDir := range w.InputDirectoryInfo.SubDirectories {
-		outputSubDir := file.GetTrunkedOutputPath(w.AbsoluteInputPath, w.AbsoluteOutputPath, subDir, true)
-		err := os.MkdirAll(outputSubDir, 755)
-		if err != nil {
-			log.Error().Err(err).Msg("Error creating output sub directory")
-			return err
+func (w *WebPHandler) summary() pterm.TableData {
+	return pterm.TableData{
+		{"Animated Gifs Converted", strconv.FormatInt(w.animatedConverted.Load(), 10)},
+		{"Images Resized", strconv.FormatInt(w.resized.Load(), 10)},
+	}
+}
+
+// skipLossyWithoutCWebP disables jpegs and pngs when there is no encoder for
+// them, see selectEncoder.
+func (w *WebPHandler) skipLossyWithoutCWebP() {
+	if !(w.JpegsEnabled || w.PngsEnabled) || !skipsLossy(w.Backend, w.Lossless) {
+		return
+	}
+	log.Warn().Msg("cwebp isn't installed, skipping jpegs and pngs instead of encoding them lossless; install cwebp or pass --lossless or --backend native")
+	w.JpegsEnabled = false
+	w.PngsEnabled = false
+}
+
+// findAnimatedGIFs remembers which of the input GIFs have more than one frame.
+func (w *WebPHandler) findAnimatedGIFs() error {
+	w.animatedGIFs = map[string]bool{}
+	for _, f := range w.InputDirectoryInfo.KnownIOFiles {
+		if f.Type != file.TypeGif {
+			continue
+		}
+		animated, errAnimated := file.IsAnimatedGIF(f.InputPath)
+		if errAnimated != nil {
+			return fmt.Errorf("%s: %w", f.InputPath, errAnimated)
+		}
+		if animated {
+			w.animatedGIFs[f.InputPath] = true
 		}
 	}
 	return nil
 }
 
-func (w *WebPHandler) Run() error {
-	// infoLogger := logging.GetInfoLogger()
-
-	pterm.DefaultSection.Println("Currently configured encoding settings.")
-	errRender := pterm.DefaultTable.WithData(pterm.TableData{
-		{"Input Directory", w.InputDirectoryInfo.Path},
-		{"Output Directory", w.OutputDirectoryInfo.Path},
-		{"Number of Files", strconv.FormatInt(w.InputDirectoryInfo.NumberOfFiles, 10)},
-		{"Number of Directories", strconv.FormatInt(w.InputDirectoryInfo.NumberOfDirectories, 10)},
-		{"Total Size Before Encoding", humanize.Bytes(uint64(w.InputDirectoryInfo.TotalSize))},
-		{"Jpegs Enabled", fmt.Sprintf("%t", w.JpegsEnabled)},
-		{"Jpegs Found", strconv.FormatInt(w.InputDirectoryInfo.JpegCount, 10)},
-		{"Pngs Enabled", fmt.Sprintf("%t", w.PngsEnabled)},
-		{"Pngs Found", strconv.FormatInt(w.InputDirectoryInfo.PngCount, 10)},
-		{"Gifs Enabled", fmt.Sprintf("%t", w.GifsEnabled)},
-		{"Gifs Found", strconv.FormatInt(w.InputDirectoryInfo.GifCount, 10)},
-		{"Lossless Enabled", fmt.Sprintf("%t", w.Lossless)},
-		{"Quality", strconv.Itoa(w.Quality)},
-	}).Render()
-	if errRender != nil {
-		log.Error().Err(errRender).Msg("Error rendering table")
-		return errRender
+func (w *WebPHandler) animationDescription() string {
+	mode := "lossy"
+	switch {
+	case w.Mixed:
+		mode = "mixed"
+	case w.Lossless:
+		mode = "lossless"
 	}
+	keyframes := func(k int) string {
+		if k < 0 {
+			return "default"
+		}
+		return strconv.Itoa(k)
+	}
+	return fmt.Sprintf("%s, min size: %t, kmin: %s, kmax: %s", mode, w.MinSize, keyframes(w.KMin), keyframes(w.KMax))
+}
 
-	confirmed, errConfirm := pterm.DefaultInteractiveConfirm.WithDefaultValue(true).Show("Are you sure you want to continue?")
-	if errConfirm != nil {
-		log.Error().Err(errConfirm).Msg("Error confirming")
-		return errConfirm
+func (w *WebPHandler) plan(f file.InputOutputInfo) (*filePlan, error) {
+	job, errJob := w.job(f)
+	if errJob != nil {
+		return nil, errJob
 	}
-	if !confirmed {
-		return nil
+	p := &filePlan{InputOutputInfo: f}
+	if w.Resize.enabled() {
+		width, height, errSize := imageSize(f.InputPath)
+		if errSize != nil {
+			return nil, errSize
+		}
+		p.width, p.height = width, height
+	}
+	step, errStep := w.step(job, w.Resize, p.width, p.height)
+	if errStep != nil {
+		return nil, errStep
 	}
+	p.settings = "webp " + w.stepKey(step)
+	p.outputs = []string{f.OutputPath}
+	p.backend = step.encoder.Name()
+	p.quality = w.fixedQuality(step)
+	p.steps = []encodeStep{step}
+	return p, nil
+}
+
+func (w *WebPHandler) encodeFile(p *filePlan) error {
+	return w.encode(p, p.steps[0])
+}
 
-	numCoresUsed := runtime.NumCPU()
-	startTime := time.Now()
-	wg := new(errgroup.Group)
-	wg.SetLimit(numCoresUsed)
+func (w *WebPHandler) finish() error {
+	return nil
+}
 
-	var totalFilesToProcess int64 = 0
-	if w.JpegsEnabled {
-		totalFilesToProcess += w.InputDirectoryInfo.JpegCount
+// encode writes the output of step and remembers the quality on p when it
+// is searched.
+func (w *WebPHandler) encode(p *filePlan, step encodeStep) error {
+	var errEncode error
+	if step.searched {
+		var quality int
+		quality, errEncode = w.Target.searchQuality(step.job, step.encoder, step.opts)
+		if errEncode == nil {
+			p.quality = &quality
+		}
+	} else {
+		errEncode = step.encoder.Encode(step.job, step.opts)
 	}
-	if w.PngsEnabled {
-		totalFilesToProcess += w.InputDirectoryInfo.PngCount
+	if errEncode == nil && step.job.Animated {
+		w.animatedConverted.Add(1)
 	}
-	if w.GifsEnabled {
-		totalFilesToProcess += w.InputDirectoryInfo.GifCount
+	if errEncode == nil && step.job.Resize {
+		w.resized.Add(1)
 	}
+	return errEncode
+}
 
-	progressBar, errProgress := pterm.DefaultProgressbar.WithTotal(int(totalFilesToProcess)).Start("Encoding files to WebP")
-	if errProgress != nil {
-		log.Error().Err(errProgress).Msg("Error creating progress bar")
-		return errProgress
-	}
-	for _, f := range w.InputDirectoryInfo.KnownIOFiles {
-		f := f
-		if w.JpegsEnabled && f.Type == file.TypeJpeg {
-			wg.Go(func() error {
-				errEncode := encodeFile(f.InputPath, f.OutputPath, w.Quality, w.Lossless)
-				progressBar.Increment()
-				return errEncode
-			})
-		}
-		if w.PngsEnabled && f.Type == file.TypePng {
-			wg.Go(func() error {
-				errEncode := encodeFile(f.InputPath, f.OutputPath, w.Quality, w.Lossless)
-				progressBar.Increment()
-				return errEncode
-			})
+func (w *WebPHandler) job(f file.InputOutputInfo) (Job, error) {
+	job := Job{InputOutputInfo: f, Animated: w.animatedGIFs[f.InputPath]}
+	if f.Type == file.TypeGif && w.Watch {
+		// Gifs added or changed while watching weren't checked by prepare.
+		animated, errAnimated := file.IsAnimatedGIF(f.InputPath)
+		if errAnimated != nil {
+			return job, errAnimated
 		}
-		if w.GifsEnabled && f.Type == file.TypeGif {
-			wg.Go(func() error {
-				errEncode := encodeFile(f.InputPath, f.OutputPath, w.Quality, w.Lossless)
-				progressBar.Increment()
-				return errEncode
-			})
-		}
-	}
-	errWait := wg.Wait()
-	if errWait != nil {
-		log.Error().Err(errWait).Msg("Error converting files")
-		return errWait
+		job.Animated = animated
 	}
+	return job, nil
+}
 
-	updatedOutputDirInfo, errOutputDirInfo := file.GetDirectoryInfo(w.AbsoluteOutputPath)
-	if errOutputDirInfo != nil {
-		log.Error().Err(errOutputDirInfo).Msg("Error getting output directory info")
-		return errOutputDirInfo
+// step picks the encoder for job with the given resize settings instead of
+// w.Resize. width and height are the size of the source, they are only used
+// when resize is enabled.
+func (w *WebPHandler) step(job Job, resize Resize, width, height int) (encodeStep, error) {
+	job.Resize = resize.enabled() && resize.needed(width, height)
+	encoder, errSelect := selectEncoder(w.Backend, job, w.Lossless)
+	if errSelect != nil {
+		return encodeStep{}, errSelect
 	}
+	return encodeStep{job: job, encoder: encoder, opts: w.options(resize), searched: w.Target.appliesTo(job, encoder)}, nil
+}
 
-	outputSizeDifference := updatedOutputDirInfo.TotalSize
-	if w.OutputDirectoryInfo.TotalSize > outputSizeDifference {
-		outputSizeDifference = w.OutputDirectoryInfo.TotalSize - updatedOutputDirInfo.TotalSize
+func (w *WebPHandler) options(resize Resize) EncodeOptions {
+	return EncodeOptions{
+		Quality:  w.Quality,
+		Lossless: w.Lossless,
+		Mixed:    w.Mixed,
+		MinSize:  w.MinSize,
+		KMin:     w.KMin,
+		KMax:     w.KMax,
+		Resize:   resize,
 	}
+}
 
-	spaceSaved := w.InputDirectoryInfo.TotalSize - updatedOutputDirInfo.TotalSize
-	if spaceSaved < 0 {
-		spaceSaved = 0
+// stepKey describes how the output of step is encoded. Resize settings are
+// left out for images they don't change.
+func (w *WebPHandler) stepKey(step encodeStep) string {
+	opts := step.opts
+	if step.searched {
+		opts.Quality = -1
+	}
+	key := step.encoder.Settings(step.job, opts)
+	if step.searched {
+		key += " target " + w.Target.String()
 	}
+	if step.job.Resize {
+		key += " resize " + step.opts.Resize.String()
+	}
+	return key
+}
 
-	totalTimeTaken := time.Since(startTime)
+// fixedQuality is the quality step is encoded at. Lossless steps have none
+// and searched ones only get theirs from encode.
+func (w *WebPHandler) fixedQuality(step encodeStep) *int {
+	if w.Lossless || step.searched || step.encoder.Name() == BackendNative {
+		return nil
+	}
+	quality := w.Quality
+	return &quality
+}
 
-	pterm.Println()
-	pterm.DefaultSection.Println("Encoding Summary")
-	errRender = pterm.DefaultTable.WithData(pterm.TableData{
-		{"Total Input File Size Before Encoding", humanize.Bytes(uint64(w.InputDirectoryInfo.TotalSize))},
-		{"Total Output File Size After Encoding", humanize.Bytes(uint64(outputSizeDifference))},
-		{"Total Space Saved", humanize.Bytes(uint64(spaceSaved))},
-		{"Total Time Taken to Encode All Files", totalTimeTaken.String()},
-	}).Render()
-	return errRender
+// validTarget rejects targets that can't change anything.
+func validTarget(target Target, lossless bool, backend string) error {
+	switch {
+	case !target.enabled():
+		return nil
+	case lossless:
+		return ErrTargetLossless
+	case backend == BackendNative:
+		return ErrTargetNeedsLossy
+	case backend == BackendAuto && !(cwebpEncoder{}).Available() && !(gif2webpEncoder{}).Available():
+		return ErrTargetNeedsLossy
+	}
+	return nil
 }
 
-func encodeFile(inputPath, outputPath string, quality int, lossless bool) error {
-	var args []string
-	if lossless {
-		args = append(args, "-lossless")
+// validEncoderFlags rejects animation settings and a quality that none of
+// the encoders the backend resolves to would use.
+func validEncoderFlags(c *cli.Context, backend string, lossless bool, resize Resize) error {
+	animated := Job{InputOutputInfo: file.InputOutputInfo{Type: file.TypeGif}, Animated: true}
+	animationFlags := c.IsSet("mixed") || c.IsSet("kmin") || c.IsSet("kmax")
+	encoder, errSelect := selectEncoder(backend, animated, lossless)
+	if animationFlags && (errSelect != nil || encoder.Name() == BackendNative) {
+		return ErrAnimationNeedsGif2WebP
+	}
+	if animationFlags && resize.enabled() {
+		log.Warn().Msg("animated gifs that need resizing are encoded with the native encoder, which ignores --mixed, --kmin and --kmax")
 	}
+	if !c.IsSet("quality") {
+		return nil
+	}
+	for _, job := range []Job{
+		{InputOutputInfo: file.InputOutputInfo{Type: file.TypeJpeg}},
+		{InputOutputInfo: file.InputOutputInfo{Type: file.TypePng}},
+		{InputOutputInfo: file.InputOutputInfo{Type: file.TypeGif}},
+		animated,
+	} {
+		if encoder, errSelect := selectEncoder(backend, job, lossless); errSelect == nil && encoder.Name() != BackendNative {
+			return nil
+		}
+	}
+	return ErrQualityNeedsLossy
+}
 
-	args = append(args, "-q", fmt.Sprintf("%d", quality), "-mt", inputPath, "-o",
-		outputPath, "-quiet")
-	cmd := exec.Command("cwebp", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func (w *WebPHandler) skipFile(*filePlan) error {
+	return nil
 }
@@ -0,0 +1,196 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ExifTimeLayout is the layout EXIF uses for DateTime fields.
+const ExifTimeLayout = "2006:01:02 15:04:05"
+
+const (
+	exifIFDPointer   = 0x8769
+	maxExifIFDCount  = 4
+	maxExifTagValues = 1 << 16
+	maxExifReadBytes = 1 << 20
+)
+
+var ErrNoExif = errors.New("no exif data found")
+
+var exifTagNames = map[uint16]string{
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013b: "Artist",
+	0x8298: "Copyright",
+	0x829a: "ExposureTime",
+	0x829d: "FNumber",
+	0x8827: "ISOSpeedRatings",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x920a: "FocalLength",
+	0xa002: "PixelXDimension",
+	0xa003: "PixelYDimension",
+	0xa405: "FocalLengthIn35mmFilm",
+	0xa431: "BodySerialNumber",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+}
+
+var exifTypeSizes = map[uint16]int64{2: 1, 3: 2, 4: 4, 5: 8, 9: 4, 10: 8}
+
+// ReadExif reads the common EXIF tags of a JPEG or TIFF based file and
+// returns them keyed by tag name, e.g. "DateTimeOriginal" or "Model". Only
+// the start of the file is read, which is where cameras put EXIF data.
+func ReadExif(path string) (map[string]string, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	data, errRead := io.ReadAll(io.LimitReader(f, maxExifReadBytes))
+	if errRead != nil {
+		return nil, errRead
+	}
+	tiff, errFind := findTIFFHeader(data)
+	if errFind != nil {
+		return nil, errFind
+	}
+	return parseTIFF(tiff)
+}
+
+func findTIFFHeader(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return data, nil
+	}
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, ErrNoExif
+	}
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xff {
+			return nil, ErrNoExif
+		}
+		marker := data[offset+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		segment := offset + 4
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if marker == 0xe1 && bytes.HasPrefix(data[segment:end], []byte("Exif\x00\x00")) {
+			return data[segment+6 : end], nil
+		}
+		offset = end
+	}
+	return nil, ErrNoExif
+}
+
+func parseTIFF(tiff []byte) (map[string]string, error) {
+	if len(tiff) < 8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+	tags := map[string]string{}
+	next := int64(order.Uint32(tiff[4:]))
+	for i := 0; i < maxExifIFDCount && next != 0; i++ {
+		pointer, errIFD := parseIFD(tiff, order, next, tags)
+		if errIFD != nil {
+			return tags, errIFD
+		}
+		next = pointer
+	}
+	return tags, nil
+}
+
+// parseIFD reads the tags of one image file directory into tags and returns
+// the offset of the EXIF sub directory, if the directory points to one.
+func parseIFD(tiff []byte, order binary.ByteOrder, offset int64, tags map[string]string) (int64, error) {
+	if offset < 0 || offset+2 > int64(len(tiff)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	count := int64(order.Uint16(tiff[offset:]))
+	var exifOffset int64
+	for i := int64(0); i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		tag := order.Uint16(tiff[entry:])
+		if tag == exifIFDPointer {
+			exifOffset = int64(order.Uint32(tiff[entry+8:]))
+			continue
+		}
+		name, known := exifTagNames[tag]
+		if !known {
+			continue
+		}
+		value, ok := exifValue(tiff, order, tiff[entry:entry+12])
+		if ok {
+			tags[name] = value
+		}
+	}
+	return exifOffset, nil
+}
+
+func exifValue(tiff []byte, order binary.ByteOrder, entry []byte) (string, bool) {
+	kind := order.Uint16(entry[2:])
+	count := int64(order.Uint32(entry[4:]))
+	size, supported := exifTypeSizes[kind]
+	if !supported || count == 0 || count > maxExifTagValues {
+		return "", false
+	}
+	raw := entry[8:12]
+	if size*count > 4 {
+		offset := int64(order.Uint32(entry[8:]))
+		if offset+size*count > int64(len(tiff)) {
+			return "", false
+		}
+		raw = tiff[offset : offset+size*count]
+	}
+	switch kind {
+	case 2:
+		return strings.TrimSpace(strings.TrimRight(string(raw[:count]), "\x00")), true
+	case 3:
+		return strconv.Itoa(int(order.Uint16(raw))), true
+	case 4:
+		return strconv.FormatUint(uint64(order.Uint32(raw)), 10), true
+	case 9:
+		return strconv.Itoa(int(int32(order.Uint32(raw)))), true
+	case 5, 10:
+		numerator := float64(order.Uint32(raw))
+		denominator := float64(order.Uint32(raw[4:]))
+		if kind == 10 {
+			numerator = float64(int32(order.Uint32(raw)))
+			denominator = float64(int32(order.Uint32(raw[4:])))
+		}
+		if denominator == 0 {
+			return "", false
+		}
+		if numerator == 1 && denominator > 1 {
+			return "1/" + strconv.FormatFloat(denominator, 'f', -1, 64), true
+		}
+		return strconv.FormatFloat(numerator/denominator, 'f', -1, 64), true
+	}
+	return "", false
+}
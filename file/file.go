@@ -53,7 +53,9 @@ func TrimFileExtension(name string) string {
 	return fileNameNoExtension
 }
 
-func GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, filePath string, isDir bool) string {
+// GetTrunkedOutputPath maps a path in the input directory to the output
+// directory. Files get the extension of the output format, e.g. ".webp".
+func GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, filePath, extension string, isDir bool) string {
 	if isDir {
 		trimmedDir := strings.TrimPrefix(filePath, absoluteInputPath)
 		return filepath.Join(absoluteOutputPath, trimmedDir)
@@ -62,7 +64,7 @@ func GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, filePath string
 	fileName := filepath.Base(filePath)
 	trunkedPath := strings.TrimPrefix(dir, absoluteInputPath)
 	fileNameNoExtension := TrimFileExtension(fileName)
-	return filepath.Join(absoluteOutputPath, trunkedPath, fileNameNoExtension+".webp")
+	return filepath.Join(absoluteOutputPath, trunkedPath, fileNameNoExtension+extension)
 }
 
 func GetFileTypeFromFilePath(path string) (types.Type, error) {
@@ -88,7 +90,19 @@ func GetFileTypeFromFilePath(path string) (types.Type, error) {
 	return fType, nil
 }
 
-func GetDirectoryInfo(directory string) (DirectoryInfo, error) {
+func fileTypeFromMIME(mime string) fileType {
+	switch mime {
+	case "image/jpeg":
+		return TypeJpeg
+	case "image/gif":
+		return TypeGif
+	case "image/png":
+		return TypePng
+	}
+	return TypeUnknown
+}
+
+func GetDirectoryInfo(directory string, filter Filter) (DirectoryInfo, error) {
 	dInfo := DirectoryInfo{
 		Path: directory,
 	}
@@ -97,10 +111,16 @@ func GetDirectoryInfo(directory string) (DirectoryInfo, error) {
 			return nil
 		}
 		if f.IsDir() {
+			if !filter.AllowsDir(directory, path) {
+				return filepath.SkipDir
+			}
 			dInfo.NumberOfDirectories++
 			dInfo.SubDirectories = append(dInfo.SubDirectories, path)
 			return nil
 		}
+		if !filter.AllowsPath(directory, path) {
+			return nil
+		}
 		fInfo := Info{
 			Path: path,
 		}
@@ -109,6 +129,9 @@ func GetDirectoryInfo(directory string) (DirectoryInfo, error) {
 			log.Error().Err(errFileType).Msg("Error getting file type")
 			return nil
 		}
+		if !filter.AllowsType(fileType) {
+			return nil
+		}
 		switch fileType.MIME.Value {
 		case "image/jpeg":
 			dInfo.JpegCount++
@@ -133,7 +156,7 @@ func GetDirectoryInfo(directory string) (DirectoryInfo, error) {
 	return dInfo, err
 }
 
-func GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, directory string) (DirectoryInfo, error) {
+func GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, directory, extension string, filter Filter) (DirectoryInfo, error) {
 	dInfo := DirectoryInfo{
 		Path: directory,
 	}
@@ -142,19 +165,28 @@ func GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, directory string)
 			return nil
 		}
 		if f.IsDir() {
+			if !filter.AllowsDir(directory, path) {
+				return filepath.SkipDir
+			}
 			dInfo.NumberOfDirectories++
 			dInfo.SubDirectories = append(dInfo.SubDirectories, path)
 			return nil
 		}
+		if !filter.AllowsPath(directory, path) {
+			return nil
+		}
 		fInfo := InputOutputInfo{
 			InputPath:  path,
-			OutputPath: GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, path, false),
+			OutputPath: GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, path, extension, false),
 		}
 		fileType, errFileType := GetFileTypeFromFilePath(path)
 		if errFileType != nil {
 			log.Error().Err(errFileType).Msg("Error getting file type")
 			return nil
 		}
+		if !filter.AllowsType(fileType) {
+			return nil
+		}
 		switch fileType.MIME.Value {
 		case "image/jpeg":
 			dInfo.JpegCount++
@@ -178,3 +210,38 @@ func GetDirectoryInfoIO(absoluteInputPath, absoluteOutputPath, directory string)
 	})
 	return dInfo, err
 }
+
+// GetInputOutputInfo checks a single file against the same filters as
+// GetDirectoryInfoIO. It returns false for files that are filtered out or
+// aren't jpegs, pngs or gifs.
+func GetInputOutputInfo(absoluteInputPath, absoluteOutputPath, path, extension string, filter Filter) (InputOutputInfo, bool, error) {
+	fInfo := InputOutputInfo{
+		InputPath:  path,
+		OutputPath: GetTrunkedOutputPath(absoluteInputPath, absoluteOutputPath, path, extension, false),
+		Type:       TypeUnknown,
+	}
+	for dir := filepath.Dir(path); dir != absoluteInputPath && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if !filter.AllowsDir(absoluteInputPath, dir) {
+			return fInfo, false, nil
+		}
+	}
+	if !filter.AllowsPath(absoluteInputPath, path) {
+		return fInfo, false, nil
+	}
+	info, errStat := os.Stat(path)
+	if errStat != nil {
+		return fInfo, false, errStat
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return fInfo, false, nil
+	}
+	fileType, errFileType := GetFileTypeFromFilePath(path)
+	if errFileType != nil {
+		return fInfo, false, errFileType
+	}
+	if !filter.AllowsType(fileType) {
+		return fInfo, false, nil
+	}
+	fInfo.Type = fileTypeFromMIME(fileType.MIME.Value)
+	return fInfo, fInfo.Type != TypeUnknown, nil
+}
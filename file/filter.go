@@ -0,0 +1,208 @@
+package file
+
+import (
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/h2non/filetype/types"
+	"github.com/urfave/cli/v2"
+)
+
+// Filter limits which entries a directory walk visits. Patterns are globs
+// matched against the slash separated path relative to the walk root, patterns
+// without a slash are matched against the name alone and ** matches any number
+// of directories. A MaxDepth of 0 means no limit.
+type Filter struct {
+	Include    []string
+	Exclude    []string
+	MaxDepth   int
+	SkipHidden bool
+	Types      []string
+}
+
+func FilterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "include",
+			Required: false,
+			Usage:    "only process files matching this glob, can be repeated (e.g. *.jpg, assets/**/*.png)",
+		},
+		&cli.StringSliceFlag{
+			Name:     "exclude",
+			Required: false,
+			Usage:    "skip files and directories matching this glob, can be repeated (e.g. node_modules, .git)",
+		},
+		&cli.IntFlag{
+			Name:     "max-depth",
+			Required: false,
+			Usage:    "how many directories deep to go, 1 only processes the top level, 0 has no limit",
+			Value:    0,
+		},
+		&cli.BoolFlag{
+			Name:     "skip-hidden",
+			Required: false,
+			Usage:    "skip files and directories whose name starts with a dot",
+			Value:    false,
+		},
+		&cli.StringSliceFlag{
+			Name:     "type",
+			Required: false,
+			Usage:    "only process files whose content is of this type, can be repeated (e.g. jpeg, png, image, image/webp)",
+		},
+	}
+}
+
+func FilterFromContext(c *cli.Context) Filter {
+	return Filter{
+		Include:    c.StringSlice("include"),
+		Exclude:    c.StringSlice("exclude"),
+		MaxDepth:   c.Int("max-depth"),
+		SkipHidden: c.Bool("skip-hidden"),
+		Types:      c.StringSlice("type"),
+	}
+}
+
+func (f Filter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.MaxDepth == 0 && !f.SkipHidden && len(f.Types) == 0
+}
+
+func (f Filter) String() string {
+	if f.IsEmpty() {
+		return "none"
+	}
+	var parts []string
+	if len(f.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, " "))
+	}
+	if len(f.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, " "))
+	}
+	if f.MaxDepth > 0 {
+		parts = append(parts, "max depth "+strconv.Itoa(f.MaxDepth))
+	}
+	if f.SkipHidden {
+		parts = append(parts, "skip hidden")
+	}
+	if len(f.Types) > 0 {
+		parts = append(parts, "type "+strings.Join(f.Types, " "))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func relativeSlashPath(root, p string) string {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func depth(rel string) int {
+	return strings.Count(rel, "/") + 1
+}
+
+// AllowsDir reports whether a walk should descend into the directory p.
+func (f Filter) AllowsDir(root, p string) bool {
+	if p == root {
+		return true
+	}
+	rel := relativeSlashPath(root, p)
+	if f.MaxDepth > 0 && depth(rel) >= f.MaxDepth {
+		return false
+	}
+	if f.SkipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return false
+	}
+	return !matchesAny(f.Exclude, rel)
+}
+
+// SelectsDir reports whether the directory p itself is selected, which is
+// separate from descending into it: a directory at the maximum depth is
+// selected but not walked into. Type filters only select files and include
+// patterns have to match the directory.
+func (f Filter) SelectsDir(root, p string) bool {
+	return len(f.Types) == 0 && f.AllowsPath(root, p)
+}
+
+// AllowsPath reports whether the file p passes the name based checks. The
+// type check needs the file contents and is done by AllowsType.
+func (f Filter) AllowsPath(root, p string) bool {
+	rel := relativeSlashPath(root, p)
+	if f.MaxDepth > 0 && depth(rel) > f.MaxDepth {
+		return false
+	}
+	if f.SkipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return false
+	}
+	if matchesAny(f.Exclude, rel) {
+		return false
+	}
+	return len(f.Include) == 0 || matchesAny(f.Include, rel)
+}
+
+func (f Filter) NeedsType() bool {
+	return len(f.Types) > 0
+}
+
+// AllowsType matches the detected type against the type filters, which can be
+// one of the fileType names, an extension such as webp, a MIME type or just
+// the MIME top level type such as image.
+func (f Filter) AllowsType(fType types.Type) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case string(fileTypeFromMIME(fType.MIME.Value)), fType.Extension, fType.MIME.Value, fType.MIME.Type:
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob matches a slash separated relative path against pattern. A
+// pattern without a slash matches the last element of the path, so "*.jpg"
+// matches "a/b/c.jpg" and "node_modules" matches "web/node_modules".
+func MatchGlob(pattern, rel string) bool {
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(rel))
+		return matched
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		matched, _ := path.Match(pattern[0], segments[0])
+		if !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+	return len(segments) == 0
+}
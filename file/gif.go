@@ -0,0 +1,88 @@
+package file
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+var ErrNotGIF = errors.New("not a gif file")
+
+// IsAnimatedGIF reports whether the GIF at path has more than one frame. It
+// only walks the block structure and doesn't decode any image data.
+func IsAnimatedGIF(path string) (bool, error) {
+	f, errOpen := os.Open(path)
+	if errOpen != nil {
+		return false, errOpen
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	r := bufio.NewReader(f)
+	header := make([]byte, 13)
+	if _, errRead := io.ReadFull(r, header); errRead != nil {
+		return false, errRead
+	}
+	if string(header[:3]) != "GIF" {
+		return false, ErrNotGIF
+	}
+	if header[10]&0x80 != 0 {
+		if _, errSkip := r.Discard(3 << (header[10]&0x07 + 1)); errSkip != nil {
+			return false, errSkip
+		}
+	}
+	frames := 0
+	for {
+		introducer, errRead := r.ReadByte()
+		if errRead != nil {
+			return false, errRead
+		}
+		switch introducer {
+		case 0x21:
+			if _, errSkip := r.Discard(1); errSkip != nil {
+				return false, errSkip
+			}
+			if errSkip := skipSubBlocks(r); errSkip != nil {
+				return false, errSkip
+			}
+		case 0x2c:
+			frames++
+			if frames > 1 {
+				return true, nil
+			}
+			descriptor := make([]byte, 9)
+			if _, errRead := io.ReadFull(r, descriptor); errRead != nil {
+				return false, errRead
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, errSkip := r.Discard(3 << (descriptor[8]&0x07 + 1)); errSkip != nil {
+					return false, errSkip
+				}
+			}
+			if _, errSkip := r.Discard(1); errSkip != nil {
+				return false, errSkip
+			}
+			if errSkip := skipSubBlocks(r); errSkip != nil {
+				return false, errSkip
+			}
+		default:
+			return false, nil
+		}
+	}
+}
+
+func skipSubBlocks(r *bufio.Reader) error {
+	for {
+		size, errRead := r.ReadByte()
+		if errRead != nil {
+			return errRead
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, errSkip := r.Discard(int(size)); errSkip != nil {
+			return errSkip
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// StateDirectory follows the XDG base directory spec and falls back to
+// ~/.local/state when XDG_STATE_HOME isn't set.
+func StateDirectory() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "dev-tools-cli"), nil
+	}
+	home, errHome := os.UserHomeDir()
+	if errHome != nil {
+		return "", errHome
+	}
+	return filepath.Join(home, ".local", "state", "dev-tools-cli"), nil
+}
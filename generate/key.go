@@ -4,11 +4,15 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"io"
 	"strconv"
+	"strings"
 
 	"github.com/pterm/pterm"
 	"github.com/urfave/cli/v2"
+
+	"DevToolsCLI/prompt"
 )
 
 var subCommandKey = &cli.Command{
@@ -18,19 +22,24 @@ var subCommandKey = &cli.Command{
 			Name:  "bytes",
 			Value: 32,
 		},
+		&cli.StringFlag{
+			Name:  "encoding",
+			Usage: "encoding of the key, hex or base64",
+			Value: "hex",
+		},
 	},
 	Action: Key,
 }
 
 func Key(c *cli.Context) error {
 	length := c.Int("bytes")
-	if !c.IsSet("bytes") {
-		confirm, errConfirm := pterm.DefaultInteractiveConfirm.WithDefaultValue(true).Show("Use default length of 32 bytes?")
+	if !c.IsSet("bytes") && prompt.Interactive(c) {
+		confirm, errConfirm := prompt.Confirm(c, "Use default length of 32 bytes?", true)
 		if errConfirm != nil {
 			return errConfirm
 		}
 		if !confirm {
-			l, errLength := pterm.DefaultInteractiveTextInput.Show("Enter the length of the key in bytes")
+			l, errLength := prompt.TextInput(c, "Enter the length of the key in bytes", strconv.Itoa(length))
 			if errLength != nil {
 				return errLength
 			}
@@ -42,9 +51,18 @@ func Key(c *cli.Context) error {
 	}
 
 	encodingOptions := []string{"Hex", "Base64"}
-	selectedEncoding, errEncoding := pterm.DefaultInteractiveSelect.WithOptions(encodingOptions).WithDefaultOption("Hex").Show("Select the encoding: ")
-	if errEncoding != nil {
-		return errEncoding
+	selectedEncoding := "Hex"
+	if strings.EqualFold(c.String("encoding"), "base64") {
+		selectedEncoding = "Base64"
+	} else if !strings.EqualFold(c.String("encoding"), "hex") {
+		return fmt.Errorf("unknown encoding %q, expected hex or base64", c.String("encoding"))
+	}
+	if !c.IsSet("encoding") {
+		var errEncoding error
+		selectedEncoding, errEncoding = prompt.Select(c, "Select the encoding: ", encodingOptions, selectedEncoding)
+		if errEncoding != nil {
+			return errEncoding
+		}
 	}
 
 	k := make([]byte, length)
@@ -9,7 +9,11 @@ require (
 	github.com/pterm/pterm v0.12.54
 	github.com/rs/zerolog v1.29.0
 	github.com/urfave/cli/v2 v2.24.4
+	golang.org/x/image v0.5.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.5.0
+	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211
+	golang.org/x/text v0.7.0
 )
 
 require (
@@ -26,7 +30,4 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/sys v0.5.0 // indirect
-	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
-	golang.org/x/text v0.6.0 // indirect
 )
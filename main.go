@@ -11,6 +11,7 @@ import (
 	"DevToolsCLI/encode"
 	"DevToolsCLI/generate"
 	"DevToolsCLI/logging"
+	"DevToolsCLI/prompt"
 )
 
 func main() {
@@ -20,7 +21,8 @@ func main() {
 
 func handleCLI() {
 	tool := &cli.App{
-		Name: "tools",
+		Name:  "tools",
+		Flags: prompt.Flags(),
 		Commands: []*cli.Command{
 			edit.Command(),
 			generate.Command(),
@@ -0,0 +1,80 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pterm/pterm"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var ErrConfirmationRequired = errors.New("confirmation required but not running interactively, rerun with --yes")
+
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:     "yes",
+			Aliases:  []string{"y"},
+			Required: false,
+			Usage:    "answer yes to every confirmation and use the default for every other prompt",
+			Value:    false,
+		},
+		&cli.BoolFlag{
+			Name:     "non-interactive",
+			Required: false,
+			Usage:    "never prompt, also enabled when stdin isn't a terminal",
+			Value:    false,
+		},
+	}
+}
+
+func Terminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// Interactive reports whether prompts can be shown, which needs a terminal on
+// stdin and neither --yes nor --non-interactive.
+func Interactive(c *cli.Context) bool {
+	if c.Bool("yes") || c.Bool("non-interactive") {
+		return false
+	}
+	return Terminal()
+}
+
+// Confirm asks a yes/no question. With --yes the answer is always yes. When
+// not running interactively a default of yes is taken as is, while a default
+// of no fails, since silently doing nothing in a script is never what's wanted.
+func Confirm(c *cli.Context, question string, defaultValue bool) (bool, error) {
+	if c.Bool("yes") {
+		return true, nil
+	}
+	if !Interactive(c) {
+		if defaultValue {
+			return true, nil
+		}
+		return false, fmt.Errorf("%w: %s", ErrConfirmationRequired, pterm.RemoveColorFromString(question))
+	}
+	return pterm.DefaultInteractiveConfirm.WithDefaultValue(defaultValue).Show(question)
+}
+
+// TextInput asks for a line of text, an empty answer and running without a
+// terminal both return defaultValue.
+func TextInput(c *cli.Context, question, defaultValue string) (string, error) {
+	if !Interactive(c) {
+		return defaultValue, nil
+	}
+	answer, err := pterm.DefaultInteractiveTextInput.Show(question)
+	if err != nil || answer != "" {
+		return answer, err
+	}
+	return defaultValue, nil
+}
+
+func Select(c *cli.Context, question string, options []string, defaultOption string) (string, error) {
+	if !Interactive(c) {
+		return defaultOption, nil
+	}
+	return pterm.DefaultInteractiveSelect.WithOptions(options).WithDefaultOption(defaultOption).Show(question)
+}